@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// alpnProtocols are the -alpn values the transport can actually speak after
+// negotiating them; h3 runs over QUIC and is never offered on a TCP handshake
+var alpnProtocols = []string{"h2", "http/1.1"}
+
+// parseALPN parses the comma-separated -alpn list, in preference order
+func parseALPN(s string) ([]string, error) {
+	var protos []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if !slices.Contains(alpnProtocols, p) {
+			return nil, fmt.Errorf("unsupported protocol %q (want %s)", p, strings.Join(alpnProtocols, " or "))
+		}
+		if !slices.Contains(protos, p) {
+			protos = append(protos, p)
+		}
+	}
+	return protos, nil
+}
+
+// offerALPN makes the TLS handshake with the target offer exactly protos.
+// Offering h2 needs the transport's HTTP/2 support, which a custom TLS
+// config otherwise leaves off.
+func offerALPN(t *http.Transport, protos []string) {
+	t.TLSClientConfig.NextProtos = protos
+	t.ForceAttemptHTTP2 = slices.Contains(protos, "h2")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseALPN(t *testing.T) {
+	if protos, err := parseALPN("h2, http/1.1,h2"); err != nil || !slices.Equal(protos, []string{"h2", "http/1.1"}) {
+		t.Fatalf("protos=%v err=%v", protos, err)
+	}
+	for _, s := range []string{"h3", "spdy/3", ""} {
+		if _, err := parseALPN(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestALPN(t *testing.T) {
+	echoProto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	srv := httptest.NewUnstartedServer(echoProto)
+	srv.EnableHTTP2 = true
+	srv.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	srv.StartTLS()
+	defer srv.Close()
+	// httptest offers only h2 when HTTP/2 is enabled
+	h2Only := httptest.NewUnstartedServer(echoProto)
+	h2Only.EnableHTTP2 = true
+	h2Only.StartTLS()
+	defer h2Only.Close()
+	proxy := startProxy(t, "http")
+
+	for _, tc := range []struct {
+		offer []string
+		want  string
+		proto string
+	}{
+		{[]string{"h2"}, "h2", "HTTP/2.0"},
+		{[]string{"http/1.1"}, "http/1.1", "HTTP/1.1"},
+		{[]string{"h2", "http/1.1"}, "h2", "HTTP/2.0"},
+	} {
+		opts := testOptions(srv.URL, tc.proto)
+		opts.insecure = true
+		opts.alpn = tc.offer
+		res := checkProxyHTTP(proxy, opts)
+		if !res.OK || res.ALPN != tc.want {
+			t.Errorf("offering %v: ok=%v alpn=%q reason=%s, want %q", tc.offer, res.OK, res.ALPN, res.Reason, tc.want)
+		}
+	}
+
+	opts := testOptions(h2Only.URL, ".")
+	opts.insecure = true
+	opts.alpn = []string{"http/1.1"}
+	if res := checkProxyHTTP(proxy, opts); res.OK || res.Reason != reasonALPN {
+		t.Fatalf("no common protocol: ok=%v reason=%s", res.OK, res.Reason)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	echoProto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served over " + r.Proto))
+	})
+	h2 := httptest.NewUnstartedServer(echoProto)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	h1 := httptest.NewTLSServer(echoProto)
+	defer h1.Close()
+	proxy := startProxy(t, "http")
+
+	for _, tc := range []struct {
+		url   string
+		http2 bool
+		proto string
+	}{
+		{h2.URL, true, "HTTP/2.0"},
+		// falling back to HTTP/1.1 is not a failure
+		{h1.URL, true, "HTTP/1.1"},
+		{h2.URL, false, ""},
+	} {
+		opts := testOptions(tc.url, "served over HTTP/")
+		opts.insecure = true
+		opts.http2 = tc.http2
+		res := checkProxyHTTP(proxy, opts)
+		if !res.OK || res.Proto != tc.proto {
+			t.Errorf("%s with http2=%v: ok=%v proto=%q reason=%s, want %q", tc.url, tc.http2, res.OK, res.Proto, res.Reason, tc.proto)
+		}
+		if tc.proto != "" && !strings.Contains(verboseLine(res), " OK "+tc.proto) {
+			t.Errorf("verbose line %q lacks %s", verboseLine(res), tc.proto)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const defaultSchemeOrder = "http,socks5,socks4"
+
+// defaultScheme is the scheme scheme-less proxies are checked with, set once
+// in main from -default-scheme
+var defaultScheme = "socks5"
+
+// probeableSchemes are the schemes -auto-scheme-order may list
+var probeableSchemes = []string{"http", "https", "socks4", "socks4a", "socks5"}
+
+// parseSchemeOrder parses the comma-separated -auto-scheme-order list
+func parseSchemeOrder(s string) ([]string, error) {
+	var order []string
+	for _, scheme := range strings.Split(s, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if !slices.Contains(probeableSchemes, scheme) {
+			return nil, fmt.Errorf("unsupported scheme %q", scheme)
+		}
+		if !slices.Contains(order, scheme) {
+			order = append(order, scheme)
+		}
+	}
+	return order, nil
+}
+
+// schemeFirst moves scheme to the front of order, adding it if missing, so an
+// explicit -default-scheme is what -auto-scheme tries first
+func schemeFirst(order []string, scheme string) []string {
+	rest := slices.DeleteFunc(slices.Clone(order), func(s string) bool { return s == scheme })
+	return append([]string{scheme}, rest...)
+}
+
+// probeSchemes checks a scheme-less proxy as each -auto-scheme-order scheme
+// in turn and returns the first passing result, whose Proxy carries the
+// scheme that worked. When none pass it returns the last failure under the
+// original address.
+func probeSchemes(addr string, opts *checkOptions) Result {
+	var res Result
+	for _, scheme := range opts.autoSchemes {
+		if res = checkOnce(scheme+"://"+addr, opts); res.OK {
+			return res
+		}
+		if opts.budgetSpent() {
+			break
+		}
+	}
+	res.Proxy = addr
+	return res
+}
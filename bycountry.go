@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// countryCodeRe guards file names built from database values
+var countryCodeRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// countryFiles writes each proxy to DIR/<CC>.txt, or DIR/unknown.txt. Files
+// are opened on first use and written unbuffered, so every line is on disk
+// as soon as the proxy is found.
+type countryFiles struct {
+	dir   string
+	files map[string]*os.File
+}
+
+// newCountryFiles creates dir and makes sure files can be created in it, so
+// that an unusable directory fails the run before any proxy is checked
+func newCountryFiles(dir string) (*countryFiles, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	probe, err := os.CreateTemp(dir, ".proxyra-*")
+	if err != nil {
+		return nil, err
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return &countryFiles{dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+func (c *countryFiles) write(res Result) error {
+	name := strings.ToUpper(res.Country)
+	if !countryCodeRe.MatchString(name) {
+		name = countryUnknown
+	}
+	f, ok := c.files[name]
+	if !ok {
+		var err error
+		if f, err = os.OpenFile(filepath.Join(c.dir, name+".txt"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return err
+		}
+		c.files[name] = f
+	}
+	_, err := f.WriteString(res.Proxy + "\n")
+	return err
+}
+
+func (c *countryFiles) Close() error {
+	var first error
+	for _, f := range c.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
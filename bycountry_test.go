@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountryFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "by-country")
+	c, err := newCountryFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range []Result{
+		{Proxy: "http://1.1.1.1:80", Country: "US"},
+		{Proxy: "http://2.2.2.2:80", Country: "de"},
+		{Proxy: "http://3.3.3.3:80", Country: "US"},
+		{Proxy: "http://4.4.4.4:80"},
+		{Proxy: "http://5.5.5.5:80", Country: "../x"},
+	} {
+		if err := c.write(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// lines are on disk before Close
+	want := map[string]string{
+		"US.txt":      "http://1.1.1.1:80\nhttp://3.3.3.3:80\n",
+		"DE.txt":      "http://2.2.2.2:80\n",
+		"unknown.txt": "http://4.4.4.4:80\nhttp://5.5.5.5:80\n",
+	}
+	for name, lines := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(b) != lines {
+			t.Errorf("%s = %q, %v; want %q", name, b, err, lines)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(want) {
+		t.Fatalf("dir holds %d files, want %d", len(entries), len(want))
+	}
+}
+
+func TestCountryFilesUnwritable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCountryFiles(filepath.Join(file, "dir")); err == nil {
+		t.Fatal("a directory under a file was accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+)
+
+const defaultCacheURL = "https://httpbin.org/get"
+
+// cache verdicts reported in Result.Cache
+const (
+	cacheFresh   = "fresh"
+	cacheCaching = "caching"
+	cacheUnknown = "unknown"
+)
+
+// checkCaching requests the echo endpoint twice through the proxy, each time
+// with a new cache-busting parameter. A fresh proxy echoes each nonce; one
+// that echoes the first but answers the second without its nonce served a
+// stored response.
+func checkCaching(proxyAddr string, opts *checkOptions) string {
+	first, firstNonce, ok := fetchBusted(proxyAddr, opts)
+	if !ok {
+		return cacheUnknown
+	}
+	second, secondNonce, ok := fetchBusted(proxyAddr, opts)
+	if !ok {
+		return cacheUnknown
+	}
+	switch {
+	case !bytes.Contains(first, firstNonce):
+		// the endpoint does not echo the query, so nothing can be concluded
+		return cacheUnknown
+	case bytes.Contains(second, secondNonce):
+		return cacheFresh
+	default:
+		return cacheCaching
+	}
+}
+
+// fetchBusted GETs the cache URL with a random query parameter added
+func fetchBusted(proxyAddr string, opts *checkOptions) ([]byte, []byte, bool) {
+	u, err := url.Parse(opts.cacheURL)
+	if err != nil {
+		return nil, nil, false
+	}
+	nonce := strconv.FormatUint(opts.rng.Uint64(), 36)
+	q := u.Query()
+	q.Set("proxyra_cb", nonce)
+	u.RawQuery = q.Encode()
+	resp, body, err := fetchBody(proxyAddr, u.String(), opts)
+	if err != nil || resp.StatusCode >= 400 {
+		return nil, nil, false
+	}
+	return body, []byte(nonce), true
+}
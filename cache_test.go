@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// startCachingProxy runs an HTTP proxy that answers every request with the
+// body of the first one it forwarded, as a cache ignoring the query would
+func startCachingProxy(t *testing.T) string {
+	t.Helper()
+	var mu sync.Mutex
+	var stored []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stored == nil {
+			resp, err := http.Get(r.URL.String())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			stored, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		w.Write(stored)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestCheckCaching(t *testing.T) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"args": "`+r.URL.RawQuery+`"}`)
+	}))
+	defer echo.Close()
+
+	opts := testOptions("", ".")
+	opts.rng = rand.New(rand.NewPCG(1, 1))
+	opts.cacheURL = echo.URL + "/get"
+	if got := checkCaching(startProxy(t, "http"), opts); got != cacheFresh {
+		t.Errorf("forwarding proxy: %s, want %s", got, cacheFresh)
+	}
+	if got := checkCaching(startCachingProxy(t), opts); got != cacheCaching {
+		t.Errorf("caching proxy: %s, want %s", got, cacheCaching)
+	}
+
+	opts.cacheURL = startTextServer(t, "no echo")
+	if got := checkCaching(startProxy(t, "http"), opts); got != cacheUnknown {
+		t.Errorf("endpoint without echo: %s, want %s", got, cacheUnknown)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+)
+
+// chainThroughSOCKS makes t reach every address by opening an HTTP CONNECT
+// tunnel through proxyAddr to the SOCKS5 next hop, then asking the next hop
+// for the address. A pass therefore means the whole chain works.
+func chainThroughSOCKS(t *http.Transport, proxyAddr string, next *url.URL, timeout float64) {
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if proxyScheme(proxyAddr) != "http" && proxyScheme(proxyAddr) != "https" {
+			return nil, fmt.Errorf("-chain-socks needs an http proxy, got %s", proxyScheme(proxyAddr))
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
+		defer cancel()
+		// the library's SOCKS handshake, run over the CONNECT tunnel
+		viaProxy := func(_ context.Context, _, hop string) (net.Conn, error) {
+			return dialTunnel(proxyAddr, hop, timeout)
+		}
+		conn, err := proxyra.DialTunnel(ctx, next.String(), addr, proxyra.TransportOptions{Dial: viaProxy})
+		if err != nil {
+			return nil, fmt.Errorf("next hop: %w", err)
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestChainSOCKS(t *testing.T) {
+	target := startTextServer(t, "chained")
+	front := startProxy(t, "http")
+	hop := startAuthSOCKS5(t, "hop", "secret")
+	_, hopAddr, _ := strings.Cut(hop, "://")
+
+	opts := testOptions(target, "chained")
+	// only the next hop knows these credentials, so a pass used it
+	opts.chainSocks = &url.URL{Scheme: "socks5", User: url.UserPassword("hop", "secret"), Host: hopAddr}
+	if res := checkProxyHTTP(front, opts); !res.OK {
+		t.Fatalf("chain failed: %s", res.Reason)
+	}
+	opts.chainSocks.User = url.UserPassword("hop", "wrong")
+	if res := checkProxyHTTP(front, opts); res.OK {
+		t.Fatal("chain passed with credentials the next hop rejects")
+	}
+
+	opts.chainSocks.User = url.UserPassword("hop", "secret")
+	if res := checkProxyHTTP(startProxy(t, "socks5"), opts); res.OK {
+		t.Fatal("a socks5 front proxy passed; -chain-socks needs http")
+	}
+}
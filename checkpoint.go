@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const checkpointVersion = 1
+
+type checkpointEntry struct {
+	OK      bool  `json:"ok"`
+	Checked int64 `json:"checked"` // Unix seconds
+}
+
+type checkpointFile struct {
+	Version int                        `json:"version"`
+	Entries map[string]checkpointEntry `json:"entries"`
+}
+
+// checkpoint remembers which proxies were already checked so an interrupted
+// run can resume. It is rewritten as a whole to a temp file that is then
+// renamed over the old one, so a crash never leaves a half-written file.
+type checkpoint struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]checkpointEntry
+	dirty   bool
+}
+
+// loadCheckpoint reads path, falling back to a leftover temp file when the
+// main file is missing or unreadable. A corrupt file is moved aside and the
+// run starts from scratch with a warning.
+func loadCheckpoint(path string, warnf func(string, ...any)) (*checkpoint, error) {
+	c := &checkpoint{path: path, entries: make(map[string]checkpointEntry)}
+
+	entries, err := readCheckpoint(path)
+	if err != nil {
+		if tmp, tmpErr := readCheckpoint(path + ".tmp"); tmpErr == nil {
+			entries, err = tmp, nil
+			c.dirty = true
+		}
+	}
+	switch {
+	case err == nil:
+		c.entries = entries
+	case errors.Is(err, os.ErrNotExist):
+	default:
+		warnf("Warning: ignoring unreadable checkpoint %s: %v\n", path, err)
+		if renameErr := os.Rename(path, path+".corrupt"); renameErr != nil && !errors.Is(renameErr, os.ErrNotExist) {
+			return nil, renameErr
+		}
+	}
+	return c, nil
+}
+
+func readCheckpoint(path string) (map[string]checkpointEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f checkpointFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", f.Version)
+	}
+	if f.Entries == nil {
+		f.Entries = make(map[string]checkpointEntry)
+	}
+	return f.Entries, nil
+}
+
+// fresh reports whether proxy was checked less than window ago; a zero
+// window accepts any recorded check
+func (c *checkpoint) fresh(proxy string, window time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[proxy]
+	return ok && (window == 0 || time.Since(time.Unix(e.Checked, 0)) < window)
+}
+
+func (c *checkpoint) record(res Result) {
+	c.mu.Lock()
+	c.entries[res.Proxy] = checkpointEntry{OK: res.OK, Checked: time.Now().Unix()}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// flush writes the checkpoint if anything changed since the last write
+func (c *checkpoint) flush() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(checkpointFile{Version: checkpointVersion, Entries: c.entries})
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// run flushes every interval until stop is closed, then flushes once more
+func (c *checkpoint) run(interval time.Duration, stop <-chan struct{}, logf func(string, ...any)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := c.flush(); err != nil {
+				logf("Warning: checkpoint write failed: %v\n", err)
+			}
+			return
+		}
+		if err := c.flush(); err != nil {
+			logf("Warning: checkpoint write failed: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	c, err := loadCheckpoint(path, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.record(Result{Proxy: "http://1.2.3.4:80", OK: true})
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file left behind after the rename: %v", err)
+	}
+
+	c, err = loadCheckpoint(path, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.fresh("http://1.2.3.4:80", time.Hour) || c.fresh("http://5.6.7.8:80", 0) {
+		t.Fatalf("entries after reload: %v", c.entries)
+	}
+}
+
+func TestCheckpointTempFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	// a crash between writing the temp file and the rename
+	c, _ := loadCheckpoint(filepath.Join(dir, "other.json"), t.Logf)
+	c.record(Result{Proxy: "socks5://1.2.3.4:1080"})
+	c.flush()
+	if err := os.Rename(filepath.Join(dir, "other.json"), path+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadCheckpoint(path, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.fresh("socks5://1.2.3.4:1080", 0) {
+		t.Fatalf("temp file not used: %v", c.entries)
+	}
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("recovered checkpoint not written back: %v", err)
+	}
+}
+
+func TestCheckpointCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"version":1,"entries":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var warned string
+	c, err := loadCheckpoint(path, func(format string, a ...any) { warned = format })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 || !strings.Contains(warned, "unreadable checkpoint") {
+		t.Fatalf("entries=%v warning=%q", c.entries, warned)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Fatalf("corrupt file not moved aside: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("corrupt file still in place: %v", err)
+	}
+}
+
+func TestRecheckAfter(t *testing.T) {
+	target := startTextServer(t, "ok")
+	stale, recent, unseen := startProxy(t, "http"), startProxy(t, "http"), startProxy(t, "http")
+	path := filepath.Join(t.TempDir(), "state.json")
+	data, _ := json.Marshal(checkpointFile{Version: checkpointVersion, Entries: map[string]checkpointEntry{
+		stale:  {OK: true, Checked: time.Now().Add(-10 * time.Hour).Unix()},
+		recent: {OK: true, Checked: time.Now().Add(-time.Minute).Unix()},
+	}})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runProxyra(t, stale+"\n"+recent+"\n"+unseen+"\n", "-u", target, "-r", "ok", "-checkpoint", path, "-recheck-after", "6h")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	got := strings.Fields(stdout)
+	if len(got) != 2 || !slices.Contains(got, stale) || !slices.Contains(got, unseen) {
+		t.Fatalf("checked %v, want the stale and unseen proxies only", got)
+	}
+
+	// the rerun refreshed the stale entry, so now every proxy is recent
+	if stdout, _, _ := runProxyra(t, stale+"\n"+recent+"\n"+unseen+"\n", "-u", target, "-r", "ok", "-checkpoint", path, "-recheck-after", "6h"); strings.TrimSpace(stdout) != "" {
+		t.Fatalf("second run rechecked %q", stdout)
+	}
+}
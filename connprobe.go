@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sync"
+)
+
+// tunnelTarget returns the host:port that raw tunnels are opened to for the
+// configured target, or "" when there is no fixed network target
+func tunnelTarget(opts *checkOptions) string {
+	if opts.tcpMode {
+		return opts.target
+	}
+	target := opts.target
+	if target == "SMART_MODE" {
+		target = "http://icanhazip.com"
+	}
+	if opts.urlTemplate != nil || isLocalTarget(target) {
+		return ""
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// probeMaxConns opens tunnels through the proxy in doubling batches while
+// holding the earlier ones open, stopping at the first failed dial or at
+// limit. It returns how many tunnels were open at the same time.
+func probeMaxConns(proxyAddr, target string, limit int, timeout float64) int {
+	var held []net.Conn
+	defer func() {
+		for _, c := range held {
+			c.Close()
+		}
+	}()
+
+	batch := 1
+	for len(held) < limit {
+		conns := make([]net.Conn, min(batch, limit-len(held)))
+		var wg sync.WaitGroup
+		for i := range conns {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if c, err := dialTunnel(proxyAddr, target, timeout); err == nil {
+					conns[i] = c
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		failed := false
+		for _, c := range conns {
+			if c == nil {
+				failed = true
+				continue
+			}
+			held = append(held, c)
+		}
+		if failed {
+			break
+		}
+		batch = len(held)
+	}
+	return len(held)
+}
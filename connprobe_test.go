@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// startLimitedSOCKS5 runs a SOCKS5 stub that drops connections beyond max
+// open at once
+func startLimitedSOCKS5(t *testing.T, max int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var open atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if open.Add(1) > max {
+				open.Add(-1)
+				conn.Close()
+				continue
+			}
+			go func() {
+				serveStubSOCKS5(conn)
+				open.Add(-1)
+			}()
+		}
+	}()
+	return "socks5://" + ln.Addr().String()
+}
+
+func TestProbeMaxConns(t *testing.T) {
+	target := startTextServer(t, "up")
+	host := tunnelTarget(testOptions(target, "."))
+	for _, tc := range []struct {
+		max         int32
+		limit, want int
+	}{
+		{5, 32, 5},
+		{1, 32, 1},
+		{64, 12, 12},
+	} {
+		if got := probeMaxConns(startLimitedSOCKS5(t, tc.max), host, tc.limit, 5); got != tc.want {
+			t.Errorf("proxy holding %d, cap %d: probed %d", tc.max, tc.limit, got)
+		}
+	}
+}
+
+func TestTunnelTarget(t *testing.T) {
+	for target, want := range map[string]string{
+		"http://example.com/x":     "example.com:80",
+		"https://example.com/x":    "example.com:443",
+		"http://example.com:8080/": "example.com:8080",
+		"http://[2001:db8::1]/":    "[2001:db8::1]:80",
+		"file:///tmp/page":         "",
+		"data:,x":                  "",
+		"SMART_MODE":               "icanhazip.com:80",
+	} {
+		if got := tunnelTarget(testOptions(target, ".")); got != want {
+			t.Errorf("tunnelTarget(%s) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runStats are counters updated by workers as proxies finish
+type runStats struct {
+	queued    atomic.Int64 // proxies to check: the whole list, or those streamed in so far
+	checked   atomic.Int64
+	passed    atomic.Int64
+	latencyMs atomic.Int64 // summed over passed proxies
+}
+
+// summary is the -stats line printed when the run ends
+func (s *runStats) summary(elapsed time.Duration) string {
+	checked, passed := s.checked.Load(), s.passed.Load()
+	avg := "-"
+	if passed > 0 {
+		avg = strconv.FormatInt(s.latencyMs.Load()/passed, 10) + "ms"
+	}
+	return fmt.Sprintf("Checked %d, passed %d, failed %d, elapsed %s, avg latency %s",
+		checked, passed, checked-passed, elapsed.Round(100*time.Millisecond), avg)
+}
+
+// pauseGate holds workers back between jobs while the run is paused
+type pauseGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// wait blocks until the gate is open or ctx is cancelled, so an interrupt
+// during a pause still lets the workers wind down
+func (g *pauseGate) wait(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		g.cond.Broadcast()
+		g.mu.Unlock()
+	})
+	defer stop()
+	g.mu.Lock()
+	for g.paused && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) set(paused bool) {
+	g.mu.Lock()
+	g.paused = paused
+	g.mu.Unlock()
+	if !paused {
+		g.cond.Broadcast()
+	}
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// serveControl listens on a Unix socket for one-line commands: pause, resume
+// and status. Checks already in flight finish; pausing only stops new ones.
+func serveControl(path string, gate *pauseGate, stats *runStats) (net.Listener, error) {
+	// A socket left over from a crashed run would make Listen fail
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleControl(conn, gate, stats)
+		}
+	}()
+	return ln, nil
+}
+
+func handleControl(conn net.Conn, gate *pauseGate, stats *runStats) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		switch cmd := strings.TrimSpace(scanner.Text()); cmd {
+		case "pause":
+			gate.set(true)
+			fmt.Fprintln(conn, "ok paused")
+		case "resume":
+			gate.set(false)
+			fmt.Fprintln(conn, "ok running")
+		case "status":
+			state := "running"
+			if gate.isPaused() {
+				state = "paused"
+			}
+			fmt.Fprintf(conn, "%s checked=%d/%d passed=%d\n", state, stats.checked.Load(), stats.queued.Load(), stats.passed.Load())
+		case "":
+		default:
+			fmt.Fprintf(conn, "error unknown command %q\n", cmd)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestControlPauseResume(t *testing.T) {
+	gate := newPauseGate()
+	stats := &runStats{}
+	stats.queued.Store(10)
+	stats.checked.Store(4)
+	stats.passed.Store(1)
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	ln, err := serveControl(path, gate, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	send := func(cmd string) string {
+		t.Helper()
+		fmt.Fprintln(conn, cmd)
+		if !replies.Scan() {
+			t.Fatalf("no reply to %q: %v", cmd, replies.Err())
+		}
+		return replies.Text()
+	}
+
+	if got := send("pause"); got != "ok paused" {
+		t.Fatalf("pause: %q", got)
+	}
+	passed := make(chan struct{})
+	go func() {
+		gate.wait(context.Background())
+		close(passed)
+	}()
+	select {
+	case <-passed:
+		t.Fatal("a worker got past the gate while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := send("status"); got != "paused checked=4/10 passed=1" {
+		t.Fatalf("status: %q", got)
+	}
+	if got := send("resume"); got != "ok running" {
+		t.Fatalf("resume: %q", got)
+	}
+	select {
+	case <-passed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resume did not release the waiting worker")
+	}
+	if got := send("frobnicate"); !strings.HasPrefix(got, "error unknown command") {
+		t.Fatalf("unknown command: %q", got)
+	}
+}
+
+func TestControlStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crashed run leaves the socket file behind
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err := serveControl(path, newPauseGate(), &runStats{})
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+}
+
+func TestInterruptWhilePaused(t *testing.T) {
+	// the first check is held in flight until the run has been paused
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	var proxies []string
+	for range 4 {
+		proxies = append(proxies, startProxy(t, "http"))
+	}
+	dir := t.TempDir()
+	sock, outPath := filepath.Join(dir, "ctl.sock"), filepath.Join(dir, "valid.txt")
+
+	cmd := exec.Command(os.Args[0], "-u", srv.URL, "-r", "ok", "-c", "1", "-control", sock, "-o", outPath, "-tee=false")
+	cmd.Env = append(os.Environ(), "PROXYRA_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(strings.Join(proxies, "\n") + "\n")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer func() {
+		cmd.Process.Kill()
+		<-exited
+	}()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if conn, err = net.Dial("unix", sock); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("control socket never came up: %v\n%s", err, stderr.String())
+		}
+	}
+	defer conn.Close()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatalf("first check never reached the target\n%s", stderr.String())
+	}
+	fmt.Fprintln(conn, "pause")
+	if reply, _ := bufio.NewReader(conn).ReadString('\n'); reply != "ok paused\n" {
+		close(release)
+		t.Fatalf("pause: %q", reply)
+	}
+	close(release)
+
+	// the first proxy finishes and the worker parks at the gate
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if data, _ := os.ReadFile(outPath); len(data) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("first result never written\n%s", stderr.String())
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	cmd.Process.Signal(syscall.SIGINT)
+
+	select {
+	case err := <-exited:
+		exited <- err
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			t.Fatalf("exit %d after one interrupt, want a clean finish\n%s", exitErr.ExitCode(), stderr.String())
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("paused run ignored the interrupt\n%s", stderr.String())
+	}
+	if data, _ := os.ReadFile(outPath); strings.TrimSpace(string(data)) != proxies[0] {
+		t.Fatalf("output %q, want just the proxy checked before the pause", data)
+	}
+}
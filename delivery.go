@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+)
+
+// deliveryRetry retries the deliveries of an output sink under policy.
+// While the receiver keeps failing, each delivery gets a single attempt, so
+// a dead receiver cannot hold up shutdown with a backlog of retries; the
+// first success lifts that.
+type deliveryRetry struct {
+	policy  proxyra.RetryPolicy
+	failing atomic.Bool
+}
+
+// sinkRetry is the policy of the webhook and Kafka sinks: up to four
+// retries, 200ms apart and doubling up to 5s
+func sinkRetry() proxyra.RetryPolicy {
+	return proxyra.ExponentialBackoff{Base: 200 * time.Millisecond, Max: 5 * time.Second, Attempts: 4}
+}
+
+// deliver calls send until it succeeds or the retries run out, and returns
+// its last error
+func (d *deliveryRetry) deliver(send func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			d.failing.Store(false)
+			return nil
+		}
+		delay, again := d.policy.NextDelay(attempt, err)
+		if d.failing.Load() || !again {
+			d.failing.Store(true)
+			return err
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+)
+
+// Dialer settings, set once in main before any check starts
+var (
+	// netnsDial opens connections inside the -netns namespace; nil means the
+	// current namespace
+	netnsDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// dialRetries is how often a failed TCP connect is retried, with -dial-retries
+	dialRetries int
+	// viaUpstream is the -via proxy every connection is tunneled through;
+	// empty means connecting directly
+	viaUpstream string
+)
+
+// proxyDial is the Dial of every transport and tunnel: dialDirect when
+// -netns or -dial-retries need it, otherwise the library's default dialer.
+// With -via, connections are tunneled through the upstream instead, which is
+// itself reached that way.
+func proxyDial() proxyra.DialFunc {
+	var base proxyra.DialFunc
+	if netnsDial != nil || dialRetries > 0 {
+		base = dialDirect
+	}
+	if viaUpstream != "" {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := proxyra.DialTunnel(ctx, viaUpstream, addr, proxyra.TransportOptions{Dial: base})
+			if err != nil {
+				return nil, fmt.Errorf("via upstream: %w", err)
+			}
+			return conn, nil
+		}
+	}
+	return base
+}
+
+// dialProxy opens a raw connection to a proxy for the probes that speak its
+// protocol by hand, over the same path as the checks: through -via when set.
+func dialProxy(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial := proxyDial(); dial != nil {
+		return dial(ctx, network, addr)
+	}
+	return dialDirect(ctx, network, addr)
+}
+
+// dialDirect opens a plain TCP connection, inside the -netns namespace when
+// set. A failed connect is retried up to dialRetries times after a short,
+// growing pause, as long as ctx allows.
+func dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := netnsDial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := dial(ctx, network, addr)
+		if err == nil || attempt >= dialRetries || ctx.Err() != nil {
+			return conn, err
+		}
+		select {
+		case <-time.After(time.Duration(attempt+1) * 50 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
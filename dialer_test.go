@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withDialer sets the dialer globals for the length of the test
+func withDialer(t *testing.T, dial func(context.Context, string, string) (net.Conn, error), retries int) {
+	t.Helper()
+	oldDial, oldRetries := netnsDial, dialRetries
+	netnsDial, dialRetries = dial, retries
+	t.Cleanup(func() { netnsDial, dialRetries = oldDial, oldRetries })
+}
+
+func TestDialRetries(t *testing.T) {
+	refused := errors.New("connection refused")
+	attempts := 0
+	flaky := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, refused
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	withDialer(t, flaky, 2)
+	conn, err := dialDirect(context.Background(), "tcp", "192.0.2.1:80")
+	if err != nil || attempts != 3 {
+		t.Fatalf("err=%v after %d attempts, want a connection on the third", err, attempts)
+	}
+	conn.Close()
+
+	attempts = 0
+	withDialer(t, flaky, 1)
+	if _, err := dialDirect(context.Background(), "tcp", "192.0.2.1:80"); !errors.Is(err, refused) || attempts != 2 {
+		t.Fatalf("err=%v after %d attempts, want the error after 2", err, attempts)
+	}
+
+	// the pauses between attempts give way to the context
+	attempts = 0
+	withDialer(t, flaky, 5)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := dialDirect(ctx, "tcp", "192.0.2.1:80"); err == nil || attempts != 1 {
+		t.Fatalf("err=%v after %d attempts, want one before the deadline", err, attempts)
+	}
+}
+
+func TestProxyDialRetries(t *testing.T) {
+	withDialer(t, nil, 0)
+	if proxyDial() != nil {
+		t.Fatal("a plain run should use the library's dialer")
+	}
+	withDialer(t, nil, 1)
+	if proxyDial() == nil {
+		t.Fatal("-dial-retries needs dialDirect")
+	}
+}
+
+// withVia sets -via for the length of the test
+func withVia(t *testing.T, upstream string) {
+	t.Helper()
+	old := viaUpstream
+	viaUpstream = upstream
+	t.Cleanup(func() { viaUpstream = old })
+}
+
+func TestProbesFollowVia(t *testing.T) {
+	squid, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer squid.Close()
+	go func() {
+		for {
+			conn, err := squid.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, "HTTP/1.1 503 Service Unavailable\r\nServer: squid/6.6\r\nContent-Length: 0\r\n\r\n")
+			conn.Close()
+		}
+	}()
+	socks := strings.TrimPrefix(startProxy(t, "socks5"), "socks5://")
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+	opts := testOptions("", ".")
+
+	withVia(t, startProxy(t, "http"))
+	if got := detectSoftware("http://"+squid.Addr().String(), opts); got != "squid/6.6" {
+		t.Fatalf("detected %q through -via, want squid/6.6", got)
+	}
+	if scheme, ok := detectProxyScheme(socks, 2); !ok || scheme != "socks5" {
+		t.Fatalf("scheme %q, %v through -via", scheme, ok)
+	}
+
+	// an unreachable upstream must not fall back to connecting directly
+	withVia(t, "http://"+dead.Addr().String())
+	if got := detectSoftware("http://"+squid.Addr().String(), opts); got != "" {
+		t.Fatalf("detected %q without reaching -via", got)
+	}
+	if scheme, ok := detectProxyScheme(socks, 2); ok {
+		t.Fatalf("scheme %q found without reaching -via", scheme)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// DNS behaviors reported by -dns-over-proxy
+const (
+	dnsProxy  = "proxy"  // only the proxy-resolvable name worked: the proxy resolves names
+	dnsClient = "client" // only the locally resolvable name worked
+	dnsBoth   = "both"
+	dnsNone   = "none"
+)
+
+// withDefaultPort appends :80 to a bare host
+func withDefaultPort(hostPort string) string {
+	if _, _, err := net.SplitHostPort(hostPort); err == nil {
+		return hostPort
+	}
+	return net.JoinHostPort(hostPort, "80")
+}
+
+// validateDNSHosts makes sure the two probe names really have the intended
+// resolvability from this machine, otherwise the classification is meaningless
+func validateDNSHosts(proxyOnly, localOnly string) error {
+	host, _, _ := net.SplitHostPort(proxyOnly)
+	if _, err := net.LookupHost(host); err == nil {
+		return fmt.Errorf("%s resolves locally, it must only resolve on the proxy side", host)
+	}
+	host, _, _ = net.SplitHostPort(localOnly)
+	if _, err := net.LookupHost(host); err != nil {
+		return fmt.Errorf("%s does not resolve locally: %w", host, err)
+	}
+	return nil
+}
+
+// classifyProxyDNS tunnels to a name only the proxy can resolve and to one
+// only this machine can resolve, and reports which of them got through.
+// Only HTTP proxies are classified; other schemes return "".
+func classifyProxyDNS(proxyAddr string, opts *checkOptions) string {
+	if scheme := proxyScheme(proxyAddr); scheme != "http" && scheme != "https" {
+		return ""
+	}
+	reach := func(hostPort string) bool {
+		conn, err := dialTunnel(proxyAddr, hostPort, opts.timeout)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	remote, local := reach(opts.dnsProxyHost), reach(opts.dnsLocalHost)
+	switch {
+	case remote && local:
+		return dnsBoth
+	case remote:
+		return dnsProxy
+	case local:
+		return dnsClient
+	default:
+		return dnsNone
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// startResolvingProxy runs an HTTP CONNECT proxy that can only reach the
+// names in hosts, each tunnelled to addr
+func startResolvingProxy(t *testing.T, addr string, hosts ...string) string {
+	t.Helper()
+	known := make(map[string]bool)
+	for _, h := range hosts {
+		known[h] = true
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect || !known[req.Host] {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					conn.Close()
+					return
+				}
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				splice(conn, upstream)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestClassifyProxyDNS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	const remote, local = "only-proxy.invalid:80", "localhost:80"
+	if err := validateDNSHosts(remote, local); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateDNSHosts(local, remote); err == nil {
+		t.Fatal("swapped probe names were accepted")
+	}
+
+	opts := testOptions("", ".")
+	opts.dnsProxyHost, opts.dnsLocalHost = remote, local
+	for want, hosts := range map[string][]string{
+		dnsBoth:   {remote, local},
+		dnsProxy:  {remote},
+		dnsClient: {local},
+		dnsNone:   nil,
+	} {
+		if got := classifyProxyDNS(startResolvingProxy(t, ln.Addr().String(), hosts...), opts); got != want {
+			t.Errorf("proxy reaching %v classified %q, want %q", hosts, got, want)
+		}
+	}
+	if got := classifyProxyDNS("socks5://127.0.0.1:1", opts); got != "" {
+		t.Errorf("SOCKS proxy classified %q", got)
+	}
+}
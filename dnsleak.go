@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verdicts reported by -dns-leak-test
+const (
+	dnsLeak        = "leak"
+	dnsNoLeak      = "no_leak"
+	dnsLeakUnknown = "unknown" // the probe name was never queried
+)
+
+// how long to wait for a probe query to reach the authoritative server
+const dnsLeakWait = 2 * time.Second
+
+// leakServer is a minimal authoritative DNS server for the leak-test zone.
+// It answers every A query under the zone with a fixed address and records
+// which resolver asked for each name.
+type leakServer struct {
+	zone   string // lower case, with trailing dot
+	answer net.IP
+	conn   net.PacketConn
+
+	mu      sync.Mutex
+	seen    map[string][]string        // query name -> resolver IPs
+	waiters map[string][]chan struct{} // query name -> goroutines waiting for it
+}
+
+func startLeakServer(listen, zone string, answer net.IP) (*leakServer, error) {
+	conn, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	s := &leakServer{
+		zone:    strings.ToLower(strings.TrimSuffix(zone, ".")) + ".",
+		answer:  answer.To4(),
+		conn:    conn,
+		seen:    make(map[string][]string),
+		waiters: make(map[string][]chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *leakServer) Close() error {
+	return s.conn.Close()
+}
+
+func (s *leakServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		name, qtype, end, ok := parseDNSQuestion(buf[:n])
+		if !ok || !strings.HasSuffix(name, s.zone) {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(addr.String())
+		s.mu.Lock()
+		s.seen[name] = append(s.seen[name], host)
+		for _, ch := range s.waiters[name] {
+			close(ch)
+		}
+		delete(s.waiters, name)
+		s.mu.Unlock()
+
+		_, _ = s.conn.WriteTo(buildDNSAnswer(buf[:end], qtype, s.answer), addr)
+	}
+}
+
+// wait blocks until name has been queried or the timeout passes, and returns
+// the resolvers that asked for it
+func (s *leakServer) wait(name string, timeout time.Duration) []string {
+	name = strings.ToLower(name) + "."
+	s.mu.Lock()
+	if seen := s.seen[name]; len(seen) > 0 {
+		s.mu.Unlock()
+		return seen
+	}
+	ch := make(chan struct{})
+	s.waiters[name] = append(s.waiters[name], ch)
+	s.mu.Unlock()
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[name]
+}
+
+// probeName returns a fresh name under the zone
+func (s *leakServer) probeName(opts *checkOptions) string {
+	return "p" + strconv.FormatUint(opts.rng.Uint64(), 36) + "." + strings.TrimSuffix(s.zone, ".")
+}
+
+// calibrate resolves a probe name with the local resolver to learn which
+// resolver addresses count as "mine"
+func (s *leakServer) calibrate(opts *checkOptions) (map[string]bool, error) {
+	local := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		name := s.probeName(opts)
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLeakWait)
+		_, _ = net.DefaultResolver.LookupHost(ctx, name)
+		cancel()
+		for _, ip := range s.wait(name, dnsLeakWait) {
+			local[ip] = true
+		}
+	}
+	if len(local) == 0 {
+		return nil, fmt.Errorf("local resolver never queried %s, check the zone delegation", s.zone)
+	}
+	return local, nil
+}
+
+// checkDNSLeak requests a unique name through the proxy and reports whether
+// the lookup reached the zone from the local resolver or from elsewhere
+func checkDNSLeak(proxyAddr string, opts *checkOptions) string {
+	name := opts.leakServer.probeName(opts)
+	// The request itself is expected to fail; only the lookup it causes matters
+	_, _, _ = fetchBody(proxyAddr, "http://"+name+"/", opts)
+
+	resolvers := opts.leakServer.wait(name, dnsLeakWait)
+	if len(resolvers) == 0 {
+		return dnsLeakUnknown
+	}
+	for _, ip := range resolvers {
+		if opts.localResolvers[ip] {
+			return dnsLeak
+		}
+	}
+	return dnsNoLeak
+}
+
+// parseDNSQuestion returns the lower-cased name and type of the first
+// question, and the offset just past it
+func parseDNSQuestion(msg []byte) (string, uint16, int, bool) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", 0, 0, false
+	}
+	var labels []string
+	i := 12
+	for {
+		if i >= len(msg) {
+			return "", 0, 0, false
+		}
+		l := int(msg[i])
+		i++
+		if l == 0 {
+			break
+		}
+		if l > 63 || i+l > len(msg) {
+			return "", 0, 0, false
+		}
+		labels = append(labels, strings.ToLower(string(msg[i:i+l])))
+		i += l
+	}
+	if i+4 > len(msg) {
+		return "", 0, 0, false
+	}
+	qtype := binary.BigEndian.Uint16(msg[i : i+2])
+	return strings.Join(labels, ".") + ".", qtype, i + 4, true
+}
+
+// buildDNSAnswer turns the header and question of a query into an
+// authoritative response, with one A record for A queries and no answer otherwise
+func buildDNSAnswer(query []byte, qtype uint16, ip net.IP) []byte {
+	resp := make([]byte, len(query), len(query)+16)
+	copy(resp, query)
+	resp[2] = 0x84 | (query[2] & 0x01) // QR, AA, keep RD
+	resp[3] = 0
+	binary.BigEndian.PutUint16(resp[4:6], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(resp[8:10], 0) // NSCOUNT
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+	if qtype != 1 || ip == nil {
+		binary.BigEndian.PutUint16(resp[6:8], 0)
+		return resp
+	}
+	binary.BigEndian.PutUint16(resp[6:8], 1) // ANCOUNT
+	resp = append(resp,
+		0xc0, 12, // name: pointer to the question
+		0, 1, 0, 1, // type A, class IN
+		0, 0, 0, 30, // TTL
+		0, 4, // RDLENGTH
+	)
+	return append(resp, ip...)
+}
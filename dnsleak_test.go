@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// startLookupProxy runs an HTTP proxy that resolves the host of every
+// request through the DNS server at resolver, then refuses it
+func startLookupProxy(t *testing.T, resolver string) string {
+	t.Helper()
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", resolver)
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				_, _ = r.LookupHost(context.Background(), req.URL.Hostname())
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestDNSLeak(t *testing.T) {
+	srv, err := startLeakServer("127.0.0.1:0", "leak.test", net.IPv4(192, 0, 2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	proxy := startLookupProxy(t, srv.conn.LocalAddr().String())
+
+	opts := testOptions("", ".")
+	opts.rng = rand.New(rand.NewPCG(1, 1))
+	opts.leakServer = srv
+
+	// every query arrives from 127.0.0.1, so the verdict depends only on
+	// whether that counts as the local resolver
+	opts.localResolvers = map[string]bool{"127.0.0.1": true}
+	if got := checkDNSLeak(proxy, opts); got != dnsLeak {
+		t.Errorf("lookup from the local resolver: %s, want %s", got, dnsLeak)
+	}
+	opts.localResolvers = map[string]bool{"192.0.2.53": true}
+	if got := checkDNSLeak(proxy, opts); got != dnsNoLeak {
+		t.Errorf("lookup from elsewhere: %s, want %s", got, dnsNoLeak)
+	}
+	if got := checkDNSLeak(startProxy(t, "socks4"), opts); got != dnsLeakUnknown {
+		t.Errorf("no lookup: %s, want %s", got, dnsLeakUnknown)
+	}
+}
+
+func TestBuildDNSAnswer(t *testing.T) {
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+		1, 'a', 4, 'l', 'e', 'a', 'k', 0, 0, 1, 0, 1}
+	name, qtype, end, ok := parseDNSQuestion(query)
+	if !ok || name != "a.leak." || qtype != 1 || end != len(query) {
+		t.Fatalf("parsed %q type %d end %d ok %v", name, qtype, end, ok)
+	}
+	resp := buildDNSAnswer(query, qtype, net.IPv4(192, 0, 2, 1).To4())
+	if resp[2]&0x80 == 0 || resp[7] != 1 || !net.IP(resp[len(resp)-4:]).Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("bad answer % x", resp)
+	}
+	if _, _, _, ok := parseDNSQuestion(query[:15]); ok {
+		t.Fatal("truncated question parsed")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodedBody returns resp's body with its Content-Encoding undone, so
+// matches and hashes see the page rather than compressed bytes. net/http
+// only decompresses gzip when it asked for it itself; with a custom
+// Accept-Encoding header the body arrives as the server sent it. Bodies in
+// other encodings, or labelled gzip without looking like it, are returned as
+// they are.
+func decodedBody(resp *http.Response) io.Reader {
+	if resp.Uncompressed {
+		return resp.Body
+	}
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		// some servers label plain bodies gzip; only decode what starts like gzip
+		br := bufio.NewReader(resp.Body)
+		if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			return br
+		}
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return strings.NewReader("")
+		}
+		return gz
+	case "br":
+		return brotli.NewReader(resp.Body)
+	default:
+		return resp.Body
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDecodedBody(t *testing.T) {
+	const page = "<title>compressed page</title>"
+	var gz, br bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(page))
+	zw.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write([]byte(page))
+	bw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gz.Bytes())
+		case "/br":
+			w.Header().Set("Content-Encoding", "br")
+			w.Write(br.Bytes())
+		case "/mislabelled":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(page))
+		}
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	for _, path := range []string{"/gzip", "/br", "/mislabelled"} {
+		opts := testOptions(srv.URL+path, "compressed page")
+		// a custom Accept-Encoding stops net/http decompressing on its own
+		opts.headers = []string{"Accept-Encoding: gzip, br"}
+		if res := checkProxyHTTP(proxy, opts); !res.OK {
+			t.Errorf("%s: %s", path, res.Reason)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+const defaultIPEchoURL = "https://checkip.amazonaws.com"
+
+// echoIPRe finds the first IPv4 or IPv6 looking token in an IP-echo response
+var echoIPRe = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}\b`)
+
+// parseEchoIP returns the first valid IP in body, or ""
+func parseEchoIP(body []byte) string {
+	for _, m := range echoIPRe.FindAll(body, -1) {
+		if ip := net.ParseIP(string(m)); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// fetchExitIP asks the IP-echo endpoint which address it sees through
+// proxyAddr, or directly when proxyAddr is empty
+func fetchExitIP(proxyAddr string, opts *checkOptions) (string, error) {
+	_, body, err := fetchBody(proxyAddr, opts.ipEchoURL, opts)
+	if err != nil {
+		return "", err
+	}
+	ip := parseEchoIP(body)
+	if ip == "" {
+		return "", fmt.Errorf("no IP address in response from %s", opts.ipEchoURL)
+	}
+	return ip, nil
+}
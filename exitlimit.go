@@ -0,0 +1,49 @@
+package main
+
+import "sync"
+
+// exitLimiter caps how many checks run at once through the same upstream.
+// Exit IPs are only known once a proxy has passed, so the limiter learns the
+// proxy host to exit mapping from finished checks and applies the cap to later
+// checks of proxies on a known host, e.g. other ports or schemes of the same
+// machine. Proxies on unseen hosts are never held back.
+type exitLimiter struct {
+	max   int
+	mu    sync.Mutex
+	exits map[string]string        // proxy host -> exit IP
+	slots map[string]chan struct{} // exit IP -> semaphore holding max tokens
+}
+
+func newExitLimiter(max int) *exitLimiter {
+	return &exitLimiter{
+		max:   max,
+		exits: make(map[string]string),
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// learn records that proxyAddr exits through exitIP
+func (l *exitLimiter) learn(proxyAddr, exitIP string) {
+	if exitIP == "" {
+		return
+	}
+	l.mu.Lock()
+	l.exits[proxyHost(proxyAddr)] = exitIP
+	if l.slots[exitIP] == nil {
+		l.slots[exitIP] = make(chan struct{}, l.max)
+	}
+	l.mu.Unlock()
+}
+
+// acquire blocks until proxyAddr may be checked and returns the function that
+// gives its slot back. It does not block when the proxy's exit is unknown.
+func (l *exitLimiter) acquire(proxyAddr string) (release func()) {
+	l.mu.Lock()
+	sem := l.slots[l.exits[proxyHost(proxyAddr)]]
+	l.mu.Unlock()
+	if sem == nil {
+		return func() {}
+	}
+	sem <- struct{}{}
+	return func() { <-sem }
+}
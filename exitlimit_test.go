@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExitLimiter(t *testing.T) {
+	l := newExitLimiter(2)
+	// unseen hosts are never held back
+	for range 5 {
+		l.acquire("http://10.0.0.1:8080")
+	}
+	l.learn("http://10.0.0.1:8080", "")
+	l.acquire("http://10.0.0.1:8080")
+
+	l.learn("http://10.0.0.1:8080", "198.51.100.1")
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	// other ports and schemes of the host share its exit
+	for _, p := range []string{"http://10.0.0.1:3128", "socks5://10.0.0.1:1080", "http://10.0.0.1:8081", "socks4://10.0.0.1:1081", "http://10.0.0.1:8888"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := l.acquire(p)
+			defer release()
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 2 {
+		t.Fatalf("%d checks ran at once through one exit, want 2", peak.Load())
+	}
+}
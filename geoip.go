@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// asnRecord is the part of a GeoLite2/GeoIP2 ASN record we read
+type asnRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// countryRecord is the part of a GeoLite2/GeoIP2 Country or City record we read
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// countryUnknown is the country of proxies whose IP is not in the database
+const countryUnknown = "unknown"
+
+// mmdbLookup resolves IPs against a MaxMind database into records of type T,
+// caching per IP
+type mmdbLookup[T any] struct {
+	db    *maxminddb.Reader
+	mu    sync.Mutex
+	cache map[string]T
+}
+
+func openMMDB[T any](path string) (*mmdbLookup[T], error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbLookup[T]{db: db, cache: make(map[string]T)}, nil
+}
+
+// lookup returns the record for ip; a miss is the zero record
+func (m *mmdbLookup[T]) lookup(ip string) T {
+	m.mu.Lock()
+	rec, ok := m.cache[ip]
+	m.mu.Unlock()
+	if ok {
+		return rec
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		_ = m.db.Lookup(parsed, &rec)
+	}
+	m.mu.Lock()
+	m.cache[ip] = rec
+	m.mu.Unlock()
+	return rec
+}
+
+func (m *mmdbLookup[T]) Close() error {
+	return m.db.Close()
+}
+
+// geoIP is the address looked up for a result: the exit IP when known,
+// otherwise the proxy host if it is a literal IP
+func geoIP(res *Result) string {
+	if res.ExitIP != "" {
+		return res.ExitIP
+	}
+	return proxyHost(res.Proxy)
+}
+
+// countryIP is the address -geoip-db locates a result by: the proxy host,
+// resolved when it is a name, or "" when it does not resolve. xray links are
+// checked through a local inbound, whose loopback host says nothing, so they
+// are located by their exit IP instead, fetched here unless a probe already did.
+func countryIP(res *Result, opts *checkOptions) string {
+	host := proxyHost(res.Proxy)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ctx, cancel := context.WithTimeout(opts.ctx, time.Duration(opts.dialTimeout()*float64(time.Second)))
+		defer cancel()
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil || len(ips) == 0 {
+			return ""
+		}
+		ip = ips[0]
+	}
+	if ip.IsLoopback() {
+		if res.ExitIP == "" && opts.ipEchoURL != "" {
+			res.ExitIP, _ = fetchExitIP(res.Proxy, opts)
+		}
+		return res.ExitIP
+	}
+	return ip.String()
+}
+
+// asnSet is a flag taking comma-separated AS numbers, with or without an AS prefix
+type asnSet map[uint]bool
+
+func (s asnSet) String() string {
+	parts := make([]string, 0, len(s))
+	for n := range s {
+		parts = append(parts, strconv.FormatUint(uint64(n), 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s asnSet) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(part)), "AS")
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return err
+		}
+		s[uint(n)] = true
+	}
+	return nil
+}
+
+// countrySet is a flag taking comma-separated ISO country codes, in any case
+type countrySet map[string]bool
+
+func (s countrySet) String() string {
+	parts := make([]string, 0, len(s))
+	for cc := range s {
+		parts = append(parts, cc)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s countrySet) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		cc := strings.ToUpper(strings.TrimSpace(part))
+		if !countryCodeRe.MatchString(cc) {
+			return fmt.Errorf("not a two-letter country code: %q", part)
+		}
+		s[cc] = true
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mmdbMap encodes a MaxMind DB map of string keys to string or uint32
+// values; strings must be shorter than 285 bytes
+func mmdbMap(fields ...any) []byte {
+	b := []byte{0xe0 | byte(len(fields)/2)}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			if len(v) < 29 {
+				b = append(b, 0x40|byte(len(v)))
+			} else {
+				b = append(b, 0x40|29, byte(len(v)-29))
+			}
+			b = append(b, v...)
+		case uint32:
+			b = append(b, 0xc4, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	return b
+}
+
+// writeMMDB writes an IPv4 MaxMind DB that maps every address to record
+func writeMMDB(t *testing.T, dbType string, record []byte) string {
+	t.Helper()
+	// one node whose both branches point at the record at data offset 0
+	const nodeCount = 1
+	ptr := nodeCount + 16
+	db := []byte{0, 0, byte(ptr), 0, 0, byte(ptr)}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, record...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, mmdbMap(
+		"node_count", uint32(nodeCount),
+		"record_size", uint32(24),
+		"ip_version", uint32(4),
+		"binary_format_major_version", uint32(2),
+		"database_type", dbType,
+	)...)
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	if err := os.WriteFile(path, db, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCountryIP(t *testing.T) {
+	opts := testOptions("", ".")
+	for _, tc := range []struct {
+		res  Result
+		want string
+	}{
+		{Result{Proxy: "socks5://203.0.113.7:1080", ExitIP: "198.51.100.1"}, "203.0.113.7"},
+		{Result{Proxy: "http://u:p@[2001:db8::1]:8080"}, "2001:db8::1"},
+		{Result{Proxy: "http://proxy.invalid:8080", ExitIP: "198.51.100.1"}, ""},
+		// an xray link's local inbound
+		{Result{Proxy: "socks5://127.0.0.1:20001", ExitIP: "198.51.100.1"}, "198.51.100.1"},
+	} {
+		if got := countryIP(&tc.res, opts); got != tc.want {
+			t.Errorf("countryIP(%s) = %q, want %q", tc.res.Proxy, got, tc.want)
+		}
+	}
+
+	// a loopback proxy without an exit IP yet has it looked up
+	opts.ipEchoURL = startTextServer(t, "198.51.100.9\n")
+	res := Result{Proxy: startProxy(t, "http")}
+	if got := countryIP(&res, opts); got != "198.51.100.9" || res.ExitIP != got {
+		t.Fatalf("countryIP = %q, exit IP %q; want the echoed 198.51.100.9", got, res.ExitIP)
+	}
+}
+
+func TestCountrySet(t *testing.T) {
+	s := countrySet{}
+	if err := s.Set("us, de"); err != nil {
+		t.Fatal(err)
+	}
+	if !s["US"] || !s["DE"] || len(s) != 2 {
+		t.Fatalf("set = %v", s)
+	}
+	for _, bad := range []string{"USA", "u1", ""} {
+		if err := (countrySet{}).Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestASN(t *testing.T) {
+	db := writeMMDB(t, "GeoLite2-ASN", mmdbMap(
+		"autonomous_system_number", uint32(16509),
+		"autonomous_system_organization", "AMAZON-02",
+	))
+	asn, err := openMMDB[asnRecord](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer asn.Close()
+	if rec := asn.lookup(geoIP(&Result{Proxy: "socks5://127.0.0.1:1080", ExitIP: "198.51.100.1"})); rec.Number != 16509 || rec.Org != "AMAZON-02" {
+		t.Fatalf("record = %+v", rec)
+	}
+	if rec := asn.lookup("not an ip"); rec.Number != 0 {
+		t.Fatalf("bad IP record = %+v", rec)
+	}
+
+	target := startTextServer(t, "ok")
+	echo := startTextServer(t, "198.51.100.1")
+	proxy := startProxy(t, "http")
+	args := []string{"-u", target, "-r", "ok", "-ip-echo-url", echo, "-asn-db", db, "-json"}
+	stdout, stderr, _ := runProxyra(t, proxy+"\n", args...)
+	var res Result
+	if err := json.Unmarshal([]byte(stdout), &res); err != nil || res.ASN != 16509 || res.ASOrg != "AMAZON-02" {
+		t.Fatalf("result %q (%v): %s", stdout, err, stderr)
+	}
+	if stdout, _, _ := runProxyra(t, proxy+"\n", append(args, "-exclude-asn", "AS16509")...); strings.TrimSpace(stdout) != "" {
+		t.Fatalf("excluded AS printed %q", stdout)
+	}
+}
+
+func TestASNSet(t *testing.T) {
+	s := asnSet{}
+	if err := s.Set("AS16509, as14061,13335"); err != nil {
+		t.Fatal(err)
+	}
+	if !s[16509] || !s[14061] || !s[13335] || len(s) != 3 {
+		t.Fatalf("set = %v", s)
+	}
+	for _, bad := range []string{"ASX", "", "99999999999"} {
+		if err := (asnSet{}).Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
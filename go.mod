@@ -2,4 +2,17 @@ module github.com/ogpourya/proxyra
 
 go 1.24.5
 
-require h12.io/socks v1.0.3
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/oschwald/maxminddb-golang v1.13.1
+	github.com/segmentio/kafka-go v0.4.51
+	golang.org/x/sys v0.21.0
+	golang.org/x/time v0.14.0
+	google.golang.org/protobuf v1.36.12
+	h12.io/socks v1.0.3
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// hostLimiter caps how many requests are in flight at once to the same
+// target host, across all workers, so several -urls, -suite or round-robin
+// targets are each treated gently however many workers there are
+type hostLimiter struct {
+	max   int
+	mu    sync.Mutex
+	slots map[string]chan struct{} // target host -> semaphore holding max tokens
+}
+
+func newHostLimiter(max int) *hostLimiter {
+	return &hostLimiter{max: max, slots: make(map[string]chan struct{})}
+}
+
+// acquire blocks until a request to target may go out, or ctx ends, and
+// returns the function that gives the slot back
+func (l *hostLimiter) acquire(ctx context.Context, target string) (release func(), err error) {
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	l.mu.Lock()
+	sem := l.slots[host]
+	if sem == nil {
+		sem = make(chan struct{}, l.max)
+		l.slots[host] = sem
+	}
+	l.mu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// probeIdleTimeout opens a tunnel through the proxy and leaves it idle,
+// checking at doubling intervals from 500ms whether it is still open, up to
+// limit. It returns how long the tunnel survived idle and whether it was
+// closed before limit; the real timeout lies between the returned duration
+// and the next check.
+func probeIdleTimeout(proxyAddr, target string, limit time.Duration, timeout float64) (time.Duration, bool, error) {
+	conn, err := dialTunnel(proxyAddr, target, timeout)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	held := time.Duration(0)
+	for wait := 500 * time.Millisecond; held < limit; wait *= 2 {
+		next := min(wait, limit)
+		time.Sleep(time.Until(start.Add(next)))
+		if !tunnelOpen(conn) {
+			return held, true, nil
+		}
+		held = next
+	}
+	return held, false, nil
+}
+
+// tunnelOpen reports whether conn is still open, using a read that times out
+// almost at once. Any data the far end sent counts as open.
+func tunnelOpen(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	return err == nil || errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startIdleClosingProxy runs an HTTP CONNECT proxy that closes every tunnel
+// after idle, whether or not it carried data
+func startIdleClosingProxy(t *testing.T, idle time.Duration) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					conn.Close()
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				time.AfterFunc(idle, func() { conn.Close() })
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestProbeIdleTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			// hold connections open without sending anything
+			if _, err := ln.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	target := ln.Addr().String()
+
+	held, closed, err := probeIdleTimeout(startIdleClosingProxy(t, 700*time.Millisecond), target, 4*time.Second, 5)
+	if err != nil || !closed || held != 500*time.Millisecond {
+		t.Fatalf("closing proxy: held=%s closed=%v err=%v, want 500ms and closed", held, closed, err)
+	}
+	held, closed, err = probeIdleTimeout(startProxy(t, "http"), target, time.Second, 5)
+	if err != nil || closed || held != time.Second {
+		t.Fatalf("keepalive proxy: held=%s closed=%v err=%v, want the full 1s", held, closed, err)
+	}
+}
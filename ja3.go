@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const defaultJA3URL = "https://tls.browserleaks.com/json"
+
+// ja3Keys are the JSON fields JA3 echo services report the fingerprint under, in order of preference
+var ja3Keys = []string{"ja3_hash", "ja3", "ja3n_hash"}
+
+var ja3HashRe = regexp.MustCompile(`\b[0-9a-f]{32}\b`)
+
+// parseJA3 extracts the JA3 hash from an echo service response, falling back
+// to the first MD5-looking token for services with other layouts
+func parseJA3(body []byte) string {
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) == nil {
+		for _, k := range ja3Keys {
+			if v, ok := fields[k].(string); ok && v != "" {
+				return v
+			}
+		}
+	}
+	return string(ja3HashRe.Find(body))
+}
+
+// fetchJA3 asks the JA3 endpoint which fingerprint it saw, through proxyAddr or directly when empty
+func fetchJA3(proxyAddr string, opts *checkOptions) (string, error) {
+	_, body, err := fetchBody(proxyAddr, opts.ja3URL, opts)
+	if err != nil {
+		return "", err
+	}
+	ja3 := parseJA3(body)
+	if ja3 == "" {
+		return "", fmt.Errorf("no JA3 fingerprint in response from %s", opts.ja3URL)
+	}
+	return ja3, nil
+}
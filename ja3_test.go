@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseJA3(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"ja3_hash":"aa","ja3":"bb"}`:                          "aa",
+		`{"ja3":"771,4865-4866,0-23,29,0"}`:                     "771,4865-4866,0-23,29,0",
+		`{"ja3n_hash":"cc"}`:                                    "cc",
+		`{"tls":{"ja3_hash":"nested"}}`:                         "",
+		`your fingerprint: e7d705a3286e19ea42f587b344ee6865 ok`: "e7d705a3286e19ea42f587b344ee6865",
+		`nothing here`:                                          "",
+	} {
+		if got := parseJA3([]byte(body)); got != want {
+			t.Errorf("parseJA3(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestJA3Changed(t *testing.T) {
+	const seen = "e7d705a3286e19ea42f587b344ee6865"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ja3_hash":"` + seen + `"}`))
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	opts := testOptions(srv.URL, ".")
+	opts.ja3URL = srv.URL
+	for baseline, changed := range map[string]bool{seen: false, "0123456789abcdef0123456789abcdef": true, "": false} {
+		opts.ja3Baseline = baseline
+		res := Result{Proxy: proxy, OK: true}
+		probeAlive(&res, proxy, opts)
+		if res.JA3 != seen || res.JA3Changed != changed {
+			t.Errorf("baseline %q: ja3=%q changed=%v", baseline, res.JA3, res.JA3Changed)
+		}
+	}
+}
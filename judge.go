@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"regexp"
+)
+
+// anonymity levels reported in Result.Anonymity
+const (
+	anonTransparent = "transparent" // the judge saw the real IP
+	anonAnonymous   = "anonymous"   // real IP hidden, but proxy headers sent
+	anonElite       = "elite"       // nothing gives the proxy away
+)
+
+// proxyHeaderRe finds headers that announce a proxy in a judge's echo,
+// whether it prints them as "Name: value" lines, JSON keys or CGI-style
+// HTTP_NAME = value variables
+var proxyHeaderRe = regexp.MustCompile(`(?im)["']?\b(?:http_)?(?:via|x[-_]forwarded[-_]for|forwarded|x[-_]real[-_]ip|x[-_]client[-_]ip|client[-_]ip|x[-_]proxy[-_]id|proxy[-_]connection)\b["']?\s*[:=]`)
+
+// judgeAnonymity fetches the judge endpoint through the proxy and classifies
+// what it saw. It returns "" when the judge could not be reached.
+func judgeAnonymity(proxyAddr string, opts *checkOptions) string {
+	resp, body, err := fetchBody(proxyAddr, opts.judgeURL, opts)
+	if err != nil || resp.StatusCode >= 400 {
+		return ""
+	}
+	return classifyAnonymity(body, opts.realIP)
+}
+
+// classifyAnonymity grades an echoed request against this machine's public IP
+func classifyAnonymity(body []byte, realIP string) string {
+	real := net.ParseIP(realIP)
+	for _, m := range echoIPRe.FindAll(body, -1) {
+		if ip := net.ParseIP(string(m)); ip != nil && ip.Equal(real) {
+			return anonTransparent
+		}
+	}
+	if proxyHeaderRe.Match(body) {
+		return anonAnonymous
+	}
+	return anonElite
+}
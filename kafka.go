@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	kafkaQueueSize = 1024
+	// kafkaMaxAttempts bounds the writer's own retries of a batch
+	kafkaMaxAttempts = 5
+)
+
+// kafkaSink publishes results to a Kafka topic as JSON. A single goroutine
+// feeds an asynchronous, batching writer, so a slow or unreachable broker
+// never stalls the checks. A write the broker refuses outright, for lack of
+// metadata or a connection, is retried, see deliveryRetry; a full queue drops
+// the result with a warning instead of blocking.
+type kafkaSink struct {
+	w     kafkaWriter
+	retry deliveryRetry
+	queue chan kafka.Message
+	done  chan struct{}
+	logf  func(string, ...any)
+}
+
+// kafkaWriter is the part of *kafka.Writer the sink uses
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+func newKafkaSink(brokers, topic string, logf func(string, ...any)) *kafkaSink {
+	return startKafkaSink(&kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.LeastBytes{},
+		BatchSize:    100,
+		BatchTimeout: time.Second,
+		MaxAttempts:  kafkaMaxAttempts,
+		Async:        true,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				logf("Warning: kafka dropped %d results: %v\n", len(messages), err)
+			}
+		},
+	}, logf)
+}
+
+// startKafkaSink starts publishing through w
+func startKafkaSink(w kafkaWriter, logf func(string, ...any)) *kafkaSink {
+	k := &kafkaSink{
+		w:     w,
+		retry: deliveryRetry{policy: sinkRetry()},
+		queue: make(chan kafka.Message, kafkaQueueSize),
+		done:  make(chan struct{}),
+		logf:  logf,
+	}
+	go k.run()
+	return k
+}
+
+func (k *kafkaSink) run() {
+	defer close(k.done)
+	for msg := range k.queue {
+		// async writes fail synchronously only on metadata or connection errors
+		err := k.retry.deliver(func() error { return k.w.WriteMessages(context.Background(), msg) })
+		if err != nil {
+			k.logf("Warning: kafka publish failed for %s: %v\n", msg.Key, err)
+		}
+	}
+}
+
+func (k *kafkaSink) publish(res Result) {
+	value, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	select {
+	case k.queue <- kafka.Message{Key: []byte(res.Proxy), Value: value}:
+	default:
+		k.logf("Warning: kafka queue full, dropping result for %s\n", res.Proxy)
+	}
+}
+
+// Close publishes whatever is still queued and flushes pending batches
+func (k *kafkaSink) Close() error {
+	close(k.queue)
+	<-k.done
+	return k.w.Close()
+}
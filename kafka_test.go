@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeProducer records what reaches it, failing the first fails writes
+type fakeProducer struct {
+	mu     sync.Mutex
+	fails  int
+	calls  int
+	got    []kafka.Message
+	closed bool
+}
+
+func (p *fakeProducer) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("leader not available")
+	}
+	p.got = append(p.got, msgs...)
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestKafkaSink(t *testing.T) {
+	p := &fakeProducer{fails: 2}
+	k := startKafkaSink(p, t.Logf)
+	results := []Result{
+		{Proxy: "http://1.1.1.1:80", OK: true, LatencyMs: 12},
+		{Proxy: "socks5://2.2.2.2:1080", OK: true, LatencyMs: 34},
+	}
+	for _, res := range results {
+		k.publish(res)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.closed {
+		t.Fatal("producer left open")
+	}
+	if p.calls != len(results)+2 {
+		t.Fatalf("%d writes, want the first retried twice", p.calls)
+	}
+	if len(p.got) != len(results) {
+		t.Fatalf("published %d of %d results", len(p.got), len(results))
+	}
+	for i, msg := range p.got {
+		var res Result
+		if err := json.Unmarshal(msg.Value, &res); err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Key) != results[i].Proxy || res.Proxy != results[i].Proxy || res.LatencyMs != results[i].LatencyMs {
+			t.Errorf("message %d: key %s, value %+v", i, msg.Key, res)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// baselineSchemes are the proxy schemes -normalize-latency measures a baseline for
+var baselineSchemes = []string{"http", "socks4", "socks4a", "socks5"}
+
+// proxyScheme returns the scheme a proxy address is checked with
+func proxyScheme(proxyAddr string) string {
+	if scheme, _, ok := strings.Cut(proxyAddr, "://"); ok {
+		return scheme
+	}
+	return defaultScheme
+}
+
+// measureBaselines times the target through a loopback stub of each scheme,
+// keeping the best of three. Subtracting it from a proxy's latency leaves the
+// time the proxy itself added, which is comparable across schemes.
+func measureBaselines(target string, opts *checkOptions) map[string]int64 {
+	baselines := make(map[string]int64, len(baselineSchemes))
+	for _, scheme := range baselineSchemes {
+		addr, stop, err := startStubProxy(scheme)
+		if err != nil {
+			continue
+		}
+		best := int64(-1)
+		for i := 0; i < 3; i++ {
+			res := performHTTPCheck(addr, target, opts.re, opts)
+			if res.Reason == reasonTimeout || res.Reason == reasonRequest || res.Reason == reasonDial {
+				continue
+			}
+			if best < 0 || res.LatencyMs < best {
+				best = res.LatencyMs
+			}
+		}
+		stop()
+		if best >= 0 {
+			baselines[scheme] = best
+		}
+	}
+	return baselines
+}
+
+// normalizeLatency subtracts the scheme's baseline, clamping at zero
+func normalizeLatency(res *Result, baselines map[string]int64) {
+	res.NormLatencyMs = max(res.LatencyMs-baselines[res.Scheme], 0)
+}
+
+// latencyOrder returns the indices of latencies from lowest to highest,
+// ties in their original order
+func latencyOrder(latencies []int64) []int {
+	idx := make([]int, len(latencies))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return latencies[idx[a]] < latencies[idx[b]]
+	})
+	return idx
+}
+
+// fastestMask marks the ceil(pct%) lowest latencies. Ties at the cutoff go
+// to whichever came first.
+func fastestMask(latencies []int64, pct float64) []bool {
+	keep := make([]bool, len(latencies))
+	n := int(math.Ceil(float64(len(latencies)) * pct / 100))
+	idx := latencyOrder(latencies)
+	for _, i := range idx[:min(n, len(idx))] {
+		keep[i] = true
+	}
+	return keep
+}
+
+// kbPerSecond is the rate of n bytes read over d, in KB/s
+func kbPerSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return float64(n) / 1024 / d.Seconds()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFastestMask(t *testing.T) {
+	tests := []struct {
+		latencies []int64
+		pct       float64
+		want      []bool
+	}{
+		{[]int64{30, 10, 20, 40}, 50, []bool{false, true, true, false}},
+		// ceil(10% of 4) keeps one
+		{[]int64{30, 10, 20, 40}, 10, []bool{false, true, false, false}},
+		// ties at the cutoff go to the first
+		{[]int64{20, 10, 20, 20}, 50, []bool{true, true, false, false}},
+		{[]int64{30, 10}, 100, []bool{true, true}},
+		{[]int64{30, 10}, 0, []bool{false, false}},
+		{nil, 50, []bool{}},
+	}
+	for _, tt := range tests {
+		if got := fastestMask(tt.latencies, tt.pct); !slices.Equal(got, tt.want) {
+			t.Errorf("fastestMask(%v, %v) = %v, want %v", tt.latencies, tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestKBPerSecond(t *testing.T) {
+	if got := kbPerSecond(512*1024, 500*time.Millisecond); got != 1024 {
+		t.Fatalf("512KB in 500ms = %v KB/s, want 1024", got)
+	}
+	if got := kbPerSecond(1024, 0); got != 1000 {
+		t.Fatalf("1KB in no time = %v KB/s, want it timed as 1ms", got)
+	}
+}
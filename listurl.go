@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// listFetchTimeout bounds downloading one -list-url
+const listFetchTimeout = 30 * time.Second
+
+// fetchProxyList downloads a proxy list for -list-url and returns its
+// non-empty lines, trimmed, as readProxiesFromFile would. It connects
+// directly: a zero Transport ignores HTTP_PROXY and friends.
+func fetchProxyList(listURL string) ([]string, error) {
+	client := &http.Client{Transport: &http.Transport{}, Timeout: listFetchTimeout}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(listURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readProxyLines(resp.Body)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isLocalTarget reports whether target is served by localTransport instead of a proxy
+func isLocalTarget(target string) bool {
+	return strings.HasPrefix(target, "file://") || strings.HasPrefix(target, "data:")
+}
+
+// localTransport answers file:// and data: requests directly, with no network.
+// It lets the matching logic run offline.
+type localTransport struct{}
+
+func (localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		body        []byte
+		contentType = "text/plain; charset=US-ASCII"
+		status      = http.StatusOK
+	)
+
+	switch req.URL.Scheme {
+	case "file":
+		data, err := os.ReadFile(req.URL.Path)
+		switch {
+		case os.IsNotExist(err):
+			status = http.StatusNotFound
+		case err != nil:
+			return nil, err
+		default:
+			body = data
+			contentType = http.DetectContentType(data)
+		}
+
+	case "data":
+		meta, payload, ok := strings.Cut(req.URL.Opaque, ",")
+		if !ok {
+			return nil, fmt.Errorf("malformed data URL")
+		}
+		if mt, isBase64 := strings.CutSuffix(meta, ";base64"); isBase64 {
+			data, err := base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, fmt.Errorf("malformed data URL: %w", err)
+			}
+			body, meta = data, mt
+		} else {
+			data, err := url.PathUnescape(payload)
+			if err != nil {
+				return nil, fmt.Errorf("malformed data URL: %w", err)
+			}
+			body = []byte(data)
+		}
+		if meta != "" {
+			contentType = meta
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported local scheme: %s", req.URL.Scheme)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalTargets(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "page.html")
+	if err := os.WriteFile(page, []byte("<html>offline marker</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the proxy is never dialed, so an unreachable one still passes
+	const proxy = "socks5://127.0.0.1:1"
+	for _, tc := range []struct {
+		target, re string
+		ok         bool
+		status     int
+	}{
+		{"file://" + page, "offline marker", true, http.StatusOK},
+		{"file://" + page, "missing", false, http.StatusOK},
+		{"file://" + page + ".gone", ".", true, http.StatusNotFound},
+		{"data:,hello%20world", "hello world", true, http.StatusOK},
+		{"data:text/html;base64,PGI+aGk8L2I+", "<b>hi</b>", true, http.StatusOK},
+	} {
+		res := checkProxyHTTP(proxy, testOptions(tc.target, tc.re))
+		if res.OK != tc.ok || res.StatusCode != tc.status {
+			t.Errorf("%s for %q: ok=%v status=%d reason=%s", tc.target, tc.re, res.OK, res.StatusCode, res.Reason)
+		}
+	}
+	if res := checkProxyHTTP(proxy, testOptions("data:;base64,%%%", ".")); res.OK {
+		t.Error("malformed data URL passed")
+	}
+}
+
+func TestLocalContentType(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "data:application/json,{}", nil)
+	resp, err := localTransport{}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("content type = %q", ct)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// newNetnsDial returns a dialer whose sockets are created inside the named
+// network namespace from /var/run/netns, as made by "ip netns add". Each
+// dial runs on a locked OS thread that is switched into the namespace and
+// back; a thread that cannot switch back is left locked so the runtime
+// discards it. Name resolution still happens in the current namespace.
+func newNetnsDial(name string) (func(context.Context, string, string) (net.Conn, error), error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join("/var/run/netns", name)
+	}
+	ns, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNetns(ns); err != nil {
+		ns.Close()
+		return nil, err
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			runtime.LockOSThread()
+			orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+			if err != nil {
+				runtime.UnlockOSThread()
+				ch <- result{err: err}
+				return
+			}
+			defer orig.Close()
+			if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+				runtime.UnlockOSThread()
+				ch <- result{err: fmt.Errorf("entering netns %s: %w", name, err)}
+				return
+			}
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, network, addr)
+			if unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET) == nil {
+				runtime.UnlockOSThread()
+			}
+			ch <- result{conn, err}
+		}()
+		r := <-ch
+		return r.conn, r.err
+	}, nil
+}
+
+// checkNetns fails unless f is a network namespace handle we may enter
+func checkNetns(f *os.File) error {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errc <- err
+			return
+		}
+		defer orig.Close()
+		if err := unix.Setns(int(f.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("%s is not an enterable network namespace: %w", f.Name(), err)
+			return
+		}
+		err = unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET)
+		if err == nil {
+			runtime.UnlockOSThread()
+		}
+		errc <- err
+	}()
+	return <-errc
+}
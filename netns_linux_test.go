@@ -0,0 +1,107 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// newTestNetns creates an empty network namespace and returns a path to it
+// that stays valid for the length of the test. The creating thread is
+// switched back, so no thread, the main one included, is left inside it.
+func newTestNetns(t *testing.T) string {
+	t.Helper()
+	type result struct {
+		ns  *os.File
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			ch <- result{err: err}
+			return
+		}
+		defer orig.Close()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			ch <- result{err: err}
+			return
+		}
+		ns, err := os.Open("/proc/thread-self/ns/net")
+		// a thread that cannot switch back stays locked and is discarded
+		if unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		ch <- result{ns, err}
+	}()
+	r := <-ch
+	if r.err != nil {
+		t.Skipf("cannot create a network namespace: %v", r.err)
+	}
+	t.Cleanup(func() { r.ns.Close() })
+	return fmt.Sprintf("/proc/self/fd/%d", r.ns.Fd())
+}
+
+func TestNetnsDial(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("entering a network namespace needs root")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the namespace we are in reaches the listener
+	here, err := newNetnsDial("/proc/self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := here(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial in the current namespace: %v", err)
+	}
+	conn.Close()
+
+	// a fresh namespace has no listener, nor even a loopback that is up
+	other, err := newNetnsDial(newTestNetns(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := other(ctx, "tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("dial inside an empty namespace reached the host listener")
+	}
+
+	// threads that dialed in the other namespace were switched back
+	conn, err = here(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial after switching back: %v", err)
+	}
+	conn.Close()
+}
+
+func TestNetnsDialErrors(t *testing.T) {
+	if _, err := newNetnsDial("proxyra-test-missing"); err == nil {
+		t.Error("missing namespace accepted")
+	}
+	notNs := filepath.Join(t.TempDir(), "plain")
+	os.WriteFile(notNs, nil, 0o644)
+	if _, err := newNetnsDial(notNs); err == nil {
+		t.Error("plain file accepted as a namespace")
+	}
+}
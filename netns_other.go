@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func newNetnsDial(string) (func(context.Context, string, string) (net.Conn, error), error) {
+	return nil, errors.New("network namespaces are only supported on Linux")
+}
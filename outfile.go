@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputFile is the -o destination, optionally gzip-compressed. It is
+// flushed every interval, or after every result when the interval is 0, so
+// an interrupted run leaves readable results behind; a .gz file cut short
+// decompresses up to the last flush.
+type outputFile struct {
+	mu        sync.Mutex
+	f         *os.File
+	buf       *bufio.Writer
+	gz        *gzip.Writer // nil when uncompressed
+	eachWrite bool         // flush after every Write
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// openOutputFile truncates path, or appends to it with appendTo. An appended
+// .gz file gains another gzip member, which gunzip reads as one stream.
+func openOutputFile(path string, compress, appendTo bool, interval time.Duration) (*outputFile, error) {
+	mode := os.O_TRUNC
+	if appendTo {
+		mode = os.O_APPEND
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|mode, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	o := &outputFile{f: f, eachWrite: interval == 0, stop: make(chan struct{}), done: make(chan struct{})}
+	var w io.Writer = f
+	if compress {
+		o.gz = gzip.NewWriter(f)
+		w = o.gz
+	}
+	o.buf = bufio.NewWriter(w)
+	if o.eachWrite {
+		close(o.done)
+	} else {
+		go o.flushLoop(interval)
+	}
+	return o, nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.buf.Write(p)
+	if err == nil && o.eachWrite {
+		err = o.flush()
+	}
+	return n, err
+}
+
+func (o *outputFile) flushLoop(interval time.Duration) {
+	defer close(o.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			_ = o.flush()
+			o.mu.Unlock()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// flush pushes buffered lines through gzip to the file; callers hold mu
+func (o *outputFile) flush() error {
+	if err := o.buf.Flush(); err != nil {
+		return err
+	}
+	if o.gz != nil {
+		return o.gz.Flush()
+	}
+	return nil
+}
+
+// Close flushes and finishes the gzip stream. It is safe to call twice, so
+// the signal handler and the normal exit path can both use it.
+func (o *outputFile) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	select {
+	case <-o.stop:
+		return nil
+	default:
+		close(o.stop)
+	}
+	err := o.flush()
+	if o.gz != nil {
+		if cerr := o.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
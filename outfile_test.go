@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readGzip decompresses path, returning what was read before any error
+func readGzip(t *testing.T, path string) (string, error) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(zr)
+	return string(data), err
+}
+
+func TestGzipOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "valid.txt.gz")
+	o, err := openOutputFile(path, true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(o, "http://10.0.0.1:8080\n")
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	// -append adds a second gzip member
+	o, err = openOutputFile(path, true, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(o, "socks5://10.0.0.2:1080\n")
+	o.Close()
+	if got, err := readGzip(t, path); err != nil || got != "http://10.0.0.1:8080\nsocks5://10.0.0.2:1080\n" {
+		t.Fatalf("read back %q, %v", got, err)
+	}
+}
+
+func TestGzipOutputInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "valid.txt.gz")
+	o, err := openOutputFile(path, true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	io.WriteString(o, "http://10.0.0.1:8080\n")
+	io.WriteString(o, "http://10.0.0.2:8080\n")
+
+	// a killed run never writes the gzip trailer, but every flushed line is there
+	got, err := readGzip(t, path)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unfinished stream read with %v", err)
+	}
+	if got != "http://10.0.0.1:8080\nhttp://10.0.0.2:8080\n" {
+		t.Fatalf("read back %q", got)
+	}
+}
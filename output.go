@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// resultSchemaVersion is bumped whenever a Result field changes meaning or is removed
+const resultSchemaVersion = "1"
+
+// schemaMeta is the optional first line of -json output
+type schemaMeta struct {
+	Type    string   `json:"type"`
+	Version string   `json:"version"`
+	Fields  []string `json:"fields"`
+}
+
+// resultFields lists the JSON field names of Result in declaration order
+func resultFields() []string {
+	t := reflect.TypeOf(Result{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func writeSchema(w io.Writer) {
+	line, _ := json.Marshal(schemaMeta{Type: "meta", Version: resultSchemaVersion, Fields: resultFields()})
+	_, _ = w.Write(append(line, '\n'))
+}
+
+func writeJSON(w io.Writer, res Result) {
+	line, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	_, _ = w.Write(append(line, '\n'))
+}
+
+// csvResults writes -output csv: a header row, then one row per valid proxy,
+// flushed as each arrives so every row reaches the output in a single Write
+type csvResults struct {
+	mu sync.Mutex
+	w  *csv.Writer
+}
+
+func newCSVResults(w io.Writer) *csvResults {
+	c := &csvResults{w: csv.NewWriter(w)}
+	_ = c.w.Write([]string{"proxy", "scheme", "status", "latency_ms", "country", "effective_url"})
+	c.w.Flush()
+	return c
+}
+
+// write adds the row for res; fields that were not measured are left empty
+func (c *csvResults) write(res Result) {
+	status, latency := "", ""
+	if res.StatusCode != 0 {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	if res.LatencyMs != 0 {
+		latency = strconv.FormatInt(res.LatencyMs, 10)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.w.Write([]string{res.Proxy, res.Scheme, status, latency, res.Country, res.EffectiveURL})
+	c.w.Flush()
+}
+
+// verboseLine describes the outcome of one check for -v, e.g.
+// "1.2.3.4:1080 FAIL timeout", "1.2.3.4:8080 FAIL bad_status (status,regex)"
+// or "1.2.3.4:3128 OK modified" with -flag-modified,
+// followed by the HTTP version with -http2 and, after a tab, the URL the
+// final response came from
+func verboseLine(res Result) string {
+	final := ""
+	if res.Proto != "" {
+		final = " " + res.Proto
+	}
+	if res.EffectiveURL != "" {
+		final += "\t" + res.EffectiveURL
+	}
+	if res.OK {
+		if res.Modified {
+			final = " modified" + final
+		}
+		return res.Proxy + " OK" + final
+	}
+	reason := res.Reason
+	if reason == "" {
+		reason = "unknown"
+	}
+	line := res.Proxy + " FAIL " + reason
+	if len(res.Failed) > 1 {
+		line += " (" + strings.Join(res.Failed, ",") + ")"
+	}
+	return line + final
+}
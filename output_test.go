@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEffectiveURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/portal/login", http.StatusFound)
+			return
+		}
+		w.Write([]byte("please log in"))
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	res := checkProxyHTTP(proxy, testOptions(srv.URL+"/", "log in"))
+	if !res.OK || res.EffectiveURL != srv.URL+"/portal/login" {
+		t.Fatalf("ok=%v effective_url=%q", res.OK, res.EffectiveURL)
+	}
+	if got, want := verboseLine(res), proxy+" OK\t"+srv.URL+"/portal/login"; got != want {
+		t.Fatalf("verbose line %q, want %q", got, want)
+	}
+
+	// without a redirect it is the target itself
+	res = checkProxyHTTP(proxy, testOptions(srv.URL+"/portal/login", "log in"))
+	if res.EffectiveURL != srv.URL+"/portal/login" {
+		t.Fatalf("effective_url without redirect = %q", res.EffectiveURL)
+	}
+}
+
+func TestCSVResults(t *testing.T) {
+	var buf bytes.Buffer
+	c := newCSVResults(&buf)
+	c.write(Result{Proxy: "http://1.2.3.4:80", Scheme: "http", StatusCode: 200, LatencyMs: 42, Country: "DE", EffectiveURL: "http://example.com/a,b"})
+	c.write(Result{Proxy: "socks5://5.6.7.8:1080"})
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"proxy", "scheme", "status", "latency_ms", "country", "effective_url"},
+		{"http://1.2.3.4:80", "http", "200", "42", "DE", "http://example.com/a,b"},
+		{"socks5://5.6.7.8:1080", "", "", "", "", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %q", rows)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Fatalf("row %d = %q, want %q", i, rows[i], want[i])
+			}
+		}
+	}
+}
+
+func TestWriteSchema(t *testing.T) {
+	var buf bytes.Buffer
+	writeSchema(&buf)
+	writeJSON(&buf, Result{Proxy: "http://1.2.3.4:80", OK: true, LatencyMs: 5})
+
+	dec := json.NewDecoder(&buf)
+	var meta schemaMeta
+	if err := dec.Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Type != "meta" || meta.Version != resultSchemaVersion || len(meta.Fields) == 0 || meta.Fields[0] != "proxy" {
+		t.Fatalf("schema line = %+v", meta)
+	}
+	var first map[string]any
+	if err := dec.Decode(&first); err != nil || first["proxy"] != "http://1.2.3.4:80" {
+		t.Fatalf("result after the schema line = %v, %v", first, err)
+	}
+
+	// every key a result can carry is announced
+	full, _ := json.Marshal(Result{
+		Proxy: "p", Scheme: "s", OK: true, Reason: "r", StatusCode: 1, LatencyMs: 1, DialMs: 1, NormLatencyMs: 1,
+		Location: "l", LocationCheck: &probeOutcome{}, Modified: true, Failed: []string{"f"}, MaxConns: 1, DNS: "d",
+		ExitIP: "e", DNSLeak: "d", Suite: []suiteOutcome{{}}, Target: "t", Samples: 1, MeanLatencyMs: 1, JitterMs: 1,
+		Country: "c", ASN: 1, ASOrg: "a", Cache: "c", IdleHeldMs: 1, IdleClosed: true, Software: "s", Anonymity: "a",
+		ALPN: "a", SpeedKBps: 1, EffectiveURL: "u", Proto: "p", JA3: "j", JA3Changed: true,
+	})
+	var keys map[string]any
+	json.Unmarshal(full, &keys)
+	fields := make(map[string]bool)
+	for _, f := range meta.Fields {
+		fields[f] = true
+	}
+	for k := range keys {
+		if !fields[k] {
+			t.Errorf("%s is missing from the schema fields", k)
+		}
+	}
+	if len(keys) != len(meta.Fields) {
+		t.Errorf("schema lists %d fields, a full result has %d", len(meta.Fields), len(keys))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// names of the success conditions, reported in Result.Failed
+const (
+	condStatus  = "status"
+	condRegex   = "regex"
+	condLatency = "latency"
+	condDial    = "dial"
+	condHash    = "hash"    // body differs from the direct fetch, with -verify-hash
+	condExclude = "exclude" // response matches -exclude-regex
+	condSpeed   = "speed"   // body downloaded slower than -min-speed
+	condHeader  = "header"  // a -header-regex did not match
+)
+
+// successPolicy is the set of conditions that must all hold for a response
+// to count as a pass. The zero value accepts any status and latency, leaving
+// the regex as the only condition.
+type successPolicy struct {
+	statuses   []int          // allowed status codes, empty = any
+	maxLatency time.Duration  // slowest acceptable time to headers, 0 = no limit
+	maxDial    time.Duration  // slowest acceptable connection setup, 0 = no limit
+	exclude    *regexp.Regexp // fails responses it matches, such as block pages; nil = none
+	headers    headerPatterns // must all match, checked before the body is read
+}
+
+// evaluate returns the conditions the response failed, in a fixed order
+func (p *successPolicy) evaluate(status int, latency, dial time.Duration, response []byte, re *regexp.Regexp) []string {
+	var failed []string
+	if len(p.statuses) > 0 && !slices.Contains(p.statuses, status) {
+		failed = append(failed, condStatus)
+	}
+	if !re.Match(response) {
+		failed = append(failed, condRegex)
+	}
+	if p.exclude != nil && p.exclude.Match(response) {
+		failed = append(failed, condExclude)
+	}
+	if p.maxLatency > 0 && latency > p.maxLatency {
+		failed = append(failed, condLatency)
+	}
+	if p.maxDial > 0 && dial > p.maxDial {
+		failed = append(failed, condDial)
+	}
+	return failed
+}
+
+// headersMatch reports whether every -header-regex matches some value of
+// its header in h; a missing header does not match
+func (p *successPolicy) headersMatch(h http.Header) bool {
+	for _, hp := range p.headers {
+		if !slices.ContainsFunc(h.Values(hp.name), hp.re.MatchString) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionReason maps a failed condition onto its failure category
+func conditionReason(cond string) string {
+	switch cond {
+	case condStatus:
+		return reasonBadStatus
+	case condLatency, condDial, condSpeed:
+		return reasonTooSlow
+	case condHash:
+		return reasonTampered
+	case condExclude:
+		return reasonExcluded
+	case condHeader:
+		return reasonHeaderMismatch
+	default:
+		return reasonNoMatch
+	}
+}
+
+// headerPattern is one -header-regex: a response header and the regex one of
+// its values must match
+type headerPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// headerPatterns is a flag taking "Name: pattern", repeatable
+type headerPatterns []headerPattern
+
+func (h *headerPatterns) String() string {
+	parts := make([]string, len(*h))
+	for i, hp := range *h {
+		parts[i] = hp.name + ": " + hp.re.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *headerPatterns) Set(value string) error {
+	name, pattern, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("want \"Name: pattern\", got %q", value)
+	}
+	re, err := regexp.Compile(strings.TrimSpace(pattern))
+	if err != nil {
+		return err
+	}
+	*h = append(*h, headerPattern{name: name, re: re})
+	return nil
+}
+
+// statusList is a flag taking comma-separated status codes, repeatable
+type statusList []int
+
+func (s *statusList) String() string {
+	parts := make([]string, len(*s))
+	for i, code := range *s {
+		parts[i] = strconv.Itoa(code)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *statusList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || code < 100 || code > 999 {
+			return fmt.Errorf("invalid status code %q", part)
+		}
+		*s = append(*s, code)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSuccessPolicy(t *testing.T) {
+	p := successPolicy{statuses: []int{200, 204}, maxLatency: time.Second}
+	re := regexp.MustCompile("ok")
+	tests := []struct {
+		name    string
+		status  int
+		latency time.Duration
+		body    string
+		want    []string
+	}{
+		{"all hold", 200, 10 * time.Millisecond, "ok", nil},
+		{"status only", 403, 10 * time.Millisecond, "ok", []string{condStatus}},
+		{"regex only", 204, 10 * time.Millisecond, "blocked", []string{condRegex}},
+		{"latency only", 200, 2 * time.Second, "ok", []string{condLatency}},
+		{"all fail", 500, 2 * time.Second, "blocked", []string{condStatus, condRegex, condLatency}},
+	}
+	for _, tt := range tests {
+		if got := p.evaluate(tt.status, tt.latency, 0, []byte(tt.body), re); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: failed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var zero successPolicy
+	if got := zero.evaluate(500, time.Hour, time.Hour, []byte("ok"), re); got != nil {
+		t.Errorf("zero policy failed %v, want only the regex checked", got)
+	}
+}
+
+func TestSuccessPolicyCheck(t *testing.T) {
+	target := startTextServer(t, "ok")
+	proxy := startProxy(t, "http")
+
+	opts := testOptions(target, "ok")
+	opts.policy = successPolicy{statuses: []int{204}}
+	res := checkProxyHTTP(proxy, opts)
+	if res.OK || !slices.Equal(res.Failed, []string{condStatus}) || res.Reason != reasonBadStatus {
+		t.Fatalf("ok=%v failed=%v reason=%s, want a status failure", res.OK, res.Failed, res.Reason)
+	}
+
+	opts.policy = successPolicy{statuses: []int{200}, maxLatency: time.Minute}
+	if res := checkProxyHTTP(proxy, opts); !res.OK {
+		t.Fatalf("reason=%s failed=%v, want a pass", res.Reason, res.Failed)
+	}
+}
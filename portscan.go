@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultScanPorts are the ports -port-scan tries on bare IPs
+const defaultScanPorts = "1080,1081,3128,3129,8000,8080,8081,8888,9050,9999"
+
+// parsePorts parses a comma-separated port list
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// bareIP returns the IP of a line that is nothing but an address, with or
+// without IPv6 brackets
+func bareIP(line string) (net.IP, bool) {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+	return ip, ip != nil
+}
+
+// expandPortScan replaces each bare IP in proxies with a proxy URL for every
+// port in ports that answers like a proxy, probing up to workers at once.
+// Other lines are kept unchanged and in place.
+func expandPortScan(proxies []string, ports []int, timeout float64, workers int) []string {
+	type probe struct {
+		line int
+		addr string
+	}
+	found := make([][]string, len(proxies))
+	probes := make(chan probe)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range probes {
+				if scheme, ok := detectProxyScheme(p.addr, timeout); ok {
+					mu.Lock()
+					found[p.line] = append(found[p.line], scheme+"://"+p.addr)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i, line := range proxies {
+		if ip, ok := bareIP(line); ok {
+			for _, port := range ports {
+				probes <- probe{line: i, addr: net.JoinHostPort(ip.String(), strconv.Itoa(port))}
+			}
+		}
+	}
+	close(probes)
+	wg.Wait()
+
+	out := make([]string, 0, len(proxies))
+	for i, line := range proxies {
+		if _, ok := bareIP(line); ok {
+			out = append(out, found[i]...)
+		} else {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// detectProxyScheme guesses the protocol spoken on addr by sending a SOCKS5
+// greeting, an HTTP CONNECT and a SOCKS4 request, each on a fresh connection,
+// and accepting the first plausible reply
+func detectProxyScheme(addr string, timeout float64) (string, bool) {
+	d := time.Duration(timeout * float64(time.Second))
+	probes := []struct {
+		scheme string
+		hello  []byte
+		accept func(*bufio.Reader) bool
+	}{
+		{"socks5", []byte{5, 1, 0}, func(r *bufio.Reader) bool {
+			var reply [2]byte
+			_, err := io.ReadFull(r, reply[:])
+			return err == nil && reply[0] == 5 && reply[1] == 0
+		}},
+		{"http", []byte("CONNECT example.com:80 HTTP/1.1\r\nHost: example.com:80\r\n\r\n"), func(r *bufio.Reader) bool {
+			line, err := r.ReadString('\n')
+			return err == nil && strings.HasPrefix(line, "HTTP/1.")
+		}},
+		{"socks4", []byte{4, 1, 0, 80, 127, 0, 0, 1, 0}, func(r *bufio.Reader) bool {
+			var reply [8]byte
+			_, err := io.ReadFull(r, reply[:])
+			return err == nil && reply[0] == 0 && reply[1] >= 0x5a && reply[1] <= 0x5d
+		}},
+	}
+	for i, p := range probes {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		conn, err := dialProxy(ctx, "tcp", addr)
+		cancel()
+		if err != nil {
+			// nothing listens; there is no point trying the other protocols
+			return "", false
+		}
+		_ = conn.SetDeadline(time.Now().Add(d))
+		ok := false
+		if _, err := conn.Write(p.hello); err == nil {
+			ok = p.accept(bufio.NewReader(conn))
+		}
+		conn.Close()
+		if ok {
+			return probes[i].scheme, true
+		}
+	}
+	return "", false
+}
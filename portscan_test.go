@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	if ports, err := parsePorts("80, 1080,65535"); err != nil || !slices.Equal(ports, []int{80, 1080, 65535}) {
+		t.Fatalf("ports=%v err=%v", ports, err)
+	}
+	for _, s := range []string{"", "80,", "0", "65536", "http"} {
+		if _, err := parsePorts(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestExpandPortScan(t *testing.T) {
+	var ports []int
+	var want []string
+	for _, scheme := range []string{"http", "socks4", "socks5"} {
+		proxy := startProxy(t, scheme)
+		_, port, _ := net.SplitHostPort(strings.TrimPrefix(proxy, scheme+"://"))
+		n, _ := strconv.Atoi(port)
+		ports = append(ports, n)
+		want = append(want, proxy)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	got := expandPortScan([]string{"socks5://10.0.0.1:1080", "127.0.0.1"}, ports, 1, 4)
+	if len(got) == 0 || got[0] != "socks5://10.0.0.1:1080" {
+		t.Fatalf("non-IP line moved or dropped: %v", got)
+	}
+	found := slices.Sorted(slices.Values(got[1:]))
+	if !slices.Equal(found, slices.Sorted(slices.Values(want))) {
+		t.Fatalf("found %v, want %v", found, want)
+	}
+}
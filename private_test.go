@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestIsPrivateHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"127.0.0.1":        true,
+		"169.254.1.1":      true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"224.0.0.1":        true,
+		"203.0.113.9":      true,
+		"::1":              true,
+		"fc00::1":          true,
+		"fe80::1":          true,
+		"2001:db8::1":      true,
+		"::ffff:10.0.0.1":  true,
+		"8.8.8.8":          false,
+		"1.1.1.1":          false,
+		"2606:4700::1111":  false,
+		"::ffff:8.8.8.8":   false,
+		"proxy.example":    false,
+		"localhost":        false,
+		"":                 false,
+		"192.168.1.1:8080": false,
+	} {
+		if got := isPrivateHost(host); got != want {
+			t.Errorf("isPrivateHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestNoPrivateFilter(t *testing.T) {
+	f := &proxyFilter{format: inputAuto, warnf: t.Logf, noPrivate: true, sampleRate: 1, seen: make(map[string]struct{})}
+	var kept []string
+	for _, p := range []string{"http://8.8.8.8:80", "socks5://192.168.0.10:1080", "http://[fd00::1]:3128", "http://proxy.example:80"} {
+		if p, ok := f.admit(p); ok {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) != 2 || kept[0] != "http://8.8.8.8:80" || kept[1] != "http://proxy.example:80" {
+		t.Fatalf("kept %v", kept)
+	}
+	if f.private != 2 {
+		t.Fatalf("counted %d private proxies, want 2", f.private)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressLine is the -progress status, rewritten in place with \r
+func progressLine(stats *runStats) string {
+	checked, passed := stats.checked.Load(), stats.passed.Load()
+	rate := 0.0
+	if checked > 0 {
+		rate = 100 * float64(passed) / float64(checked)
+	}
+	return fmt.Sprintf("\rchecked %d/%d, passed %d (%.1f%%)", checked, stats.queued.Load(), passed, rate)
+}
+
+// runProgress rewrites the progress line every interval until stop is
+// closed, then writes a final one and ends it with a newline. mu is the
+// stderr lock shared with the workers' log lines.
+func runProgress(w io.Writer, mu *sync.Mutex, stats *runStats, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			_, _ = io.WriteString(w, progressLine(stats))
+			mu.Unlock()
+		case <-stop:
+			mu.Lock()
+			_, _ = io.WriteString(w, progressLine(stats)+"\n")
+			mu.Unlock()
+			return
+		}
+	}
+}
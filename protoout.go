@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxProtoMessage bounds a single decoded message, so a corrupt length
+// prefix cannot make readProtoResult allocate without limit
+const maxProtoMessage = 16 << 20
+
+// protoEncoder appends the fields of one message, leaving out zero values
+// the way proto3 does
+type protoEncoder []byte
+
+func (e *protoEncoder) str(num protowire.Number, v string) {
+	if v != "" {
+		*e = protowire.AppendTag(*e, num, protowire.BytesType)
+		*e = protowire.AppendString(*e, v)
+	}
+}
+
+func (e *protoEncoder) int(num protowire.Number, v int64) {
+	if v != 0 {
+		*e = protowire.AppendTag(*e, num, protowire.VarintType)
+		*e = protowire.AppendVarint(*e, uint64(v))
+	}
+}
+
+func (e *protoEncoder) bool(num protowire.Number, v bool) {
+	if v {
+		e.int(num, 1)
+	}
+}
+
+func (e *protoEncoder) message(num protowire.Number, m []byte) {
+	*e = protowire.AppendTag(*e, num, protowire.BytesType)
+	*e = protowire.AppendBytes(*e, m)
+}
+
+// marshalResult encodes res as the Result message of result.proto
+func marshalResult(res Result) []byte {
+	var e protoEncoder
+	e.str(1, res.Proxy)
+	e.str(2, res.Scheme)
+	e.bool(3, res.OK)
+	e.str(4, res.Reason)
+	e.int(5, int64(res.StatusCode))
+	e.int(6, res.LatencyMs)
+	e.int(7, res.DialMs)
+	e.int(8, res.NormLatencyMs)
+	e.str(9, res.Location)
+	for _, f := range res.Failed {
+		e = protowire.AppendTag(e, 10, protowire.BytesType)
+		e = protowire.AppendString(e, f)
+	}
+	e.int(11, int64(res.MaxConns))
+	e.str(12, res.DNS)
+	e.str(13, res.ExitIP)
+	e.str(14, res.DNSLeak)
+	for _, s := range res.Suite {
+		var m protoEncoder
+		m.str(1, s.URL)
+		m.bool(2, s.OK)
+		m.bool(3, s.Required)
+		m.str(4, s.Reason)
+		m.int(5, s.LatencyMs)
+		e.message(15, m)
+	}
+	e.str(16, res.Target)
+	e.int(17, int64(res.Samples))
+	e.int(18, res.MeanLatencyMs)
+	e.int(19, res.JitterMs)
+	e.str(20, res.Country)
+	e.int(21, int64(res.ASN))
+	e.str(22, res.ASOrg)
+	e.str(23, res.Cache)
+	e.int(24, res.IdleHeldMs)
+	e.bool(25, res.IdleClosed)
+	e.str(26, res.Software)
+	e.str(27, res.JA3)
+	e.bool(28, res.JA3Changed)
+	e.str(29, res.ALPN)
+	e.str(30, res.Anonymity)
+	e.int(31, res.SpeedKBps)
+	e.str(32, res.EffectiveURL)
+	e.str(33, res.Proto)
+	if c := res.LocationCheck; c != nil {
+		var m protoEncoder
+		m.bool(1, c.OK)
+		m.str(2, c.Reason)
+		m.int(3, int64(c.StatusCode))
+		m.int(4, c.LatencyMs)
+		e.message(34, m)
+	}
+	e.bool(35, res.Modified)
+	return e
+}
+
+// protoFields walks the fields of one message, calling fn with each field's
+// number and, by wire type, its varint or its bytes. Unknown wire types
+// are skipped.
+func protoFields(b []byte, fn func(num protowire.Number, v uint64, raw []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			raw, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, 0, raw)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// unmarshalResult decodes a Result message of result.proto
+func unmarshalResult(b []byte) (Result, error) {
+	var res Result
+	var nestedErr error
+	err := protoFields(b, func(num protowire.Number, v uint64, raw []byte) {
+		s := string(raw)
+		switch num {
+		case 1:
+			res.Proxy = s
+		case 2:
+			res.Scheme = s
+		case 3:
+			res.OK = v != 0
+		case 4:
+			res.Reason = s
+		case 5:
+			res.StatusCode = int(int32(v))
+		case 6:
+			res.LatencyMs = int64(v)
+		case 7:
+			res.DialMs = int64(v)
+		case 8:
+			res.NormLatencyMs = int64(v)
+		case 9:
+			res.Location = s
+		case 10:
+			res.Failed = append(res.Failed, s)
+		case 11:
+			res.MaxConns = int(int32(v))
+		case 12:
+			res.DNS = s
+		case 13:
+			res.ExitIP = s
+		case 14:
+			res.DNSLeak = s
+		case 15:
+			var o suiteOutcome
+			err := protoFields(raw, func(num protowire.Number, v uint64, raw []byte) {
+				switch num {
+				case 1:
+					o.URL = string(raw)
+				case 2:
+					o.OK = v != 0
+				case 3:
+					o.Required = v != 0
+				case 4:
+					o.Reason = string(raw)
+				case 5:
+					o.LatencyMs = int64(v)
+				}
+			})
+			nestedErr = errors.Join(nestedErr, err)
+			res.Suite = append(res.Suite, o)
+		case 16:
+			res.Target = s
+		case 17:
+			res.Samples = int(int32(v))
+		case 18:
+			res.MeanLatencyMs = int64(v)
+		case 19:
+			res.JitterMs = int64(v)
+		case 20:
+			res.Country = s
+		case 21:
+			res.ASN = uint(uint32(v))
+		case 22:
+			res.ASOrg = s
+		case 23:
+			res.Cache = s
+		case 24:
+			res.IdleHeldMs = int64(v)
+		case 25:
+			res.IdleClosed = v != 0
+		case 26:
+			res.Software = s
+		case 27:
+			res.JA3 = s
+		case 28:
+			res.JA3Changed = v != 0
+		case 29:
+			res.ALPN = s
+		case 30:
+			res.Anonymity = s
+		case 31:
+			res.SpeedKBps = int64(v)
+		case 32:
+			res.EffectiveURL = s
+		case 33:
+			res.Proto = s
+		case 34:
+			var c probeOutcome
+			err := protoFields(raw, func(num protowire.Number, v uint64, raw []byte) {
+				switch num {
+				case 1:
+					c.OK = v != 0
+				case 2:
+					c.Reason = string(raw)
+				case 3:
+					c.StatusCode = int(int32(v))
+				case 4:
+					c.LatencyMs = int64(v)
+				}
+			})
+			nestedErr = errors.Join(nestedErr, err)
+			res.LocationCheck = &c
+		case 35:
+			res.Modified = v != 0
+		}
+	})
+	return res, errors.Join(err, nestedErr)
+}
+
+// protoFile writes -o-proto output: length-delimited Result messages
+type protoFile struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func createProtoFile(path string) (*protoFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &protoFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (p *protoFile) write(res Result) error {
+	msg := marshalResult(res)
+	if _, err := p.w.Write(protowire.AppendVarint(nil, uint64(len(msg)))); err != nil {
+		return err
+	}
+	_, err := p.w.Write(msg)
+	return err
+}
+
+func (p *protoFile) Close() error {
+	err := p.w.Flush()
+	return errors.Join(err, p.f.Close())
+}
+
+// readProtoResult reads the next length-delimited Result written by
+// protoFile, returning io.EOF at a clean end of stream
+func readProtoResult(r *bufio.Reader) (Result, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Result{}, err
+	}
+	if size > maxProtoMessage {
+		return Result{}, fmt.Errorf("proto message of %d bytes exceeds limit", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return Result{}, noEOF(err)
+	}
+	return unmarshalResult(msg)
+}
+
+// noEOF turns io.EOF inside a message into io.ErrUnexpectedEOF
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProtoFileRoundTrip(t *testing.T) {
+	results := []Result{
+		{
+			Proxy: "socks5://u:p@10.0.0.1:1080", Scheme: "socks5", OK: true, StatusCode: 200,
+			LatencyMs: 120, DialMs: 40, NormLatencyMs: 30, Location: "http://example.com/next",
+			LocationCheck: &probeOutcome{OK: true, StatusCode: 200, LatencyMs: 55},
+			MaxConns:      8, DNS: "proxy", ExitIP: "203.0.113.7", DNSLeak: "none",
+			Suite: []suiteOutcome{
+				{URL: "http://a.example", OK: true, Required: true, LatencyMs: 10},
+				{URL: "http://b.example", Reason: reasonTimeout},
+			},
+			Target: "http://a.example", Samples: 3, MeanLatencyMs: 110, JitterMs: 9,
+			Country: "DE", ASN: 64500, ASOrg: "Example AS", Cache: "fresh",
+			IdleHeldMs: 3000, IdleClosed: true, Software: "squid", Anonymity: "elite",
+			ALPN: "h2", SpeedKBps: 2048, EffectiveURL: "http://a.example/", Proto: "HTTP/2.0",
+			JA3: "771,4865-4866", JA3Changed: true,
+		},
+		{Proxy: "http://10.0.0.2:8080", Reason: reasonBadStatus, StatusCode: 503, Failed: []string{condStatus, condRegex}},
+	}
+
+	path := filepath.Join(t.TempDir(), "results.pb")
+	w, err := createProtoFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if err := w.write(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for _, want := range results {
+		got, err := readProtoResult(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("read back\n%+v\nwant\n%+v", got, want)
+		}
+	}
+	if _, err := readProtoResult(r); err != io.EOF {
+		t.Fatalf("after the last message: %v, want io.EOF", err)
+	}
+}
+
+func TestProtoFileTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.pb")
+	w, err := createProtoFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write(Result{Proxy: "http://10.0.0.1:8080", OK: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readProtoResult(bufio.NewReader(bytes.NewReader(b[:len(b)-3]))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated message: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -4,30 +4,117 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
-	"h12.io/socks"
-
+	"github.com/ogpourya/proxyra/proxyra"
 	"github.com/ogpourya/proxyra/xray"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultReadLimit      = 64 * 1024        // response body read for matching, unless -read-limit
+	maxReadLimit          = 16 * 1024 * 1024 // ceiling for -read-limit, which 0 also means
+	maxLineBytes          = 1024 * 1024
+	defaultMaxHeaderBytes = 256 * 1024       // cap on response header size
+	drainLimitBytes       = 64 * 1024 * 1024 // safety cap for -drain-body
+	speedTestLimitBytes   = 64 * 1024 * 1024 // most of a body -speed-test downloads
 )
 
+// failure categories reported for rejected proxies
 const (
-	readLimitBytes = 64 * 1024 // read up to 64 KB
-	maxLineBytes   = 1024 * 1024
+	reasonTimeout        = proxyra.ReasonTimeout
+	reasonRequest        = proxyra.ReasonRequest
+	reasonDial           = proxyra.ReasonDial
+	reasonBadStatus      = proxyra.ReasonBadStatus
+	reasonNoMatch        = proxyra.ReasonNoMatch
+	reasonTooSlow        = "too_slow"
+	reasonTampered       = "tampered"
+	reasonBudget         = "budget_exhausted"
+	reasonHeaderTooLarge = proxyra.ReasonHeaderTooLarge
+	reasonSSLStrip       = "ssl_stripping"
+	reasonALPN           = "alpn_mismatch"
+	reasonTLSVerify      = proxyra.ReasonTLSVerify
+	reasonAuth           = proxyra.ReasonAuth
+	reasonJitter         = "too_jittery"
+	reasonNoScheme       = "no_scheme"
+	reasonExcludedASN    = "excluded_asn"
+	reasonCountry        = "country_not_allowed"
+	reasonExcluded       = "excluded_match"
+	reasonRedirects      = "too_many_redirects"
+	reasonHeaderMismatch = "header_mismatch"
+	reasonIPUnchanged    = "ip_unchanged"
 )
 
+// errTooManyRedirects ends a redirect chain longer than -max-redirects
+var errTooManyRedirects = errors.New("too many redirects")
+
+// Result describes the outcome of checking a single proxy
+type Result struct {
+	Proxy         string         `json:"proxy"`
+	Scheme        string         `json:"scheme,omitempty"`
+	OK            bool           `json:"ok"`
+	Reason        string         `json:"reason,omitempty"`          // failure category, empty when OK or uncategorized
+	StatusCode    int            `json:"status,omitempty"`          // HTTP status of the checked response
+	LatencyMs     int64          `json:"latency_ms,omitempty"`      // time until response headers arrived
+	DialMs        int64          `json:"dial_ms,omitempty"`         // part of it spent connecting through the proxy, handshakes included
+	NormLatencyMs int64          `json:"latency_norm_ms,omitempty"` // latency minus the scheme baseline, with -normalize-latency
+	Location      string         `json:"location,omitempty"`        // redirect target, set with -probe-location
+	LocationCheck *probeOutcome  `json:"location_check,omitempty"`  // outcome of the separate check of Location
+	Modified      bool           `json:"modified,omitempty"`        // body differs from the direct fetch, with -verify-hash
+	Failed        []string       `json:"failed,omitempty"`          // success conditions that did not hold
+	MaxConns      int            `json:"max_conns,omitempty"`       // concurrent tunnels held open, with -conn-probe
+	DNS           string         `json:"dns,omitempty"`             // where names are resolved, with -dns-over-proxy
+	ExitIP        string         `json:"exit_ip,omitempty"`         // address the IP-echo endpoint saw
+	DNSLeak       string         `json:"dns_leak,omitempty"`        // leak verdict, with -dns-leak-test
+	Suite         []suiteOutcome `json:"suite,omitempty"`           // per-target outcomes, with -suite
+	Target        string         `json:"target,omitempty"`          // target checked, with -fallback-url or -round-robin-targets
+	Samples       int            `json:"samples,omitempty"`         // successful latency samples, with -samples
+	MeanLatencyMs int64          `json:"latency_mean_ms,omitempty"` // mean over the samples
+	JitterMs      int64          `json:"jitter_ms,omitempty"`       // standard deviation of the samples
+	Country       string         `json:"country,omitempty"`         // ISO country code of the proxy host, with -geoip-db
+	ASN           uint           `json:"asn,omitempty"`             // AS number of the exit IP, with -asn-db
+	ASOrg         string         `json:"as_org,omitempty"`          // AS organization of the exit IP
+	Cache         string         `json:"cache,omitempty"`           // fresh, caching or unknown, with -detect-cache
+	IdleHeldMs    int64          `json:"idle_held_ms,omitempty"`    // longest idle time a tunnel survived, with -probe-keepalive-idle
+	IdleClosed    bool           `json:"idle_closed,omitempty"`     // the proxy closed the idle tunnel before the cap
+	Software      string         `json:"software,omitempty"`        // best guess at the HTTP proxy's software, with -detect-software
+	Anonymity     string         `json:"anonymity,omitempty"`       // transparent, anonymous or elite, with -judge
+	ALPN          string         `json:"alpn,omitempty"`            // protocol negotiated with an https target
+	SpeedKBps     int64          `json:"speed_kbps,omitempty"`      // body download rate in KB/s, with -speed-test
+	EffectiveURL  string         `json:"effective_url,omitempty"`   // URL of the final response, after any redirects
+	Proto         string         `json:"proto,omitempty"`           // HTTP version of the response, e.g. HTTP/2.0, with -http2
+
+	JA3        string `json:"ja3,omitempty"`
+	JA3Changed bool   `json:"ja3_changed,omitempty"` // JA3 differs from the direct baseline
+}
+
+// probeOutcome is the result of the -probe-location check of a redirect target
+type probeOutcome struct {
+	OK         bool   `json:"ok"`
+	Reason     string `json:"reason,omitempty"`
+	StatusCode int    `json:"status,omitempty"`
+	LatencyMs  int64  `json:"latency_ms,omitempty"`
+}
+
 // read proxies from stdin (pipe mode)
 func readProxiesFromStdin() ([]string, error) {
 	fi, err := os.Stdin.Stat()
@@ -37,17 +124,7 @@ func readProxiesFromStdin() ([]string, error) {
 	if (fi.Mode() & os.ModeCharDevice) != 0 {
 		return nil, nil
 	}
-	var list []string
-	scanner := bufio.NewScanner(os.Stdin)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, maxLineBytes)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			list = append(list, line)
-		}
-	}
-	return list, scanner.Err()
+	return readProxyLines(os.Stdin)
 }
 
 // read proxies from file
@@ -57,8 +134,13 @@ func readProxiesFromFile(path string) ([]string, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return readProxyLines(f)
+}
+
+// readProxyLines returns the non-empty lines of r, trimmed
+func readProxyLines(r io.Reader) ([]string, error) {
 	var list []string
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, maxLineBytes)
 	for scanner.Scan() {
@@ -82,216 +164,248 @@ func isXrayLink(s string) bool {
 		strings.HasPrefix(s, "wg://")
 }
 
-// remove duplicates
-func uniqProxies(proxies []string) []string {
-	seen := make(map[string]struct{}, len(proxies))
-	out := make([]string, 0, len(proxies))
-	for _, p := range proxies {
-		if _, ok := seen[p]; !ok {
-			seen[p] = struct{}{}
-			out = append(out, p)
-		}
+// normalizeCredentials percent-encodes the userinfo part of a proxy address so
+// passwords containing '@', ':' or '/' survive url.Parse. The credentials are
+// taken as raw text: everything before the last '@' is userinfo, and the user
+// name ends at the first ':'.
+func normalizeCredentials(proxyAddr string) string {
+	scheme, rest := "", proxyAddr
+	if i := strings.Index(proxyAddr, "://"); i >= 0 {
+		scheme, rest = proxyAddr[:i+3], proxyAddr[i+3:]
 	}
-	return out
-}
-
-// build transport with full proxy support (http, socks4, socks4a, socks5)
-func newTransport(proxyAddr string, timeout float64, insecure bool) (*http.Transport, error) {
-	// accept scheme-less proxy like "1.2.3.4:1080" and default to socks5 as common choice
-	if !strings.Contains(proxyAddr, "://") {
-		proxyAddr = "socks5://" + proxyAddr
+	at := strings.LastIndex(rest, "@")
+	if at < 0 {
+		return proxyAddr
 	}
-
-	u, err := url.Parse(proxyAddr)
-	if err != nil {
-		return nil, err
+	userinfo, host := rest[:at], rest[at+1:]
+	var ui *url.Userinfo
+	if user, pass, ok := strings.Cut(userinfo, ":"); ok {
+		ui = url.UserPassword(user, pass)
+	} else {
+		ui = url.User(userinfo)
 	}
+	return scheme + ui.String() + "@" + host
+}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: insecure,
-			MinVersion:         tls.VersionTLS12,
-		},
-		DisableCompression:  false,
-		MaxIdleConns:        0,
-		IdleConnTimeout:     0,
-		MaxIdleConnsPerHost: -1,
-		DisableKeepAlives:   true,
-		TLSHandshakeTimeout: 10 * time.Second,
-	}
-
-	switch u.Scheme {
-	case "http", "https":
-		transport.Proxy = http.ProxyURL(u)
-
-	case "socks4", "socks4a", "socks5":
-		// h12.io/socks returns a dial func of signature func(network, addr string) (net.Conn, error)
-		dialSocks := socks.Dial(proxyAddr)
-
-		// Wrap the returned dial function to honor context and avoid leaks.
-		// We also use the caller context deadline, which in your code is set by NewRequestWithContext.
-		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// If caller already has a deadline, prefer that. Otherwise set an internal timeout.
-			// Use the timeout parameter only as a fallback.
-			dctx := ctx
-			var cancel context.CancelFunc
-			if _, ok := ctx.Deadline(); !ok && timeout > 0 {
-				dctx, cancel = context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
-			}
-
-			ch := make(chan struct {
-				conn net.Conn
-				err  error
-			}, 1)
-
-			go func() {
-				conn, err := dialSocks(network, addr)
-				// try to send result; if caller already gave up, close the conn to avoid leak
-				select {
-				case ch <- struct {
-					conn net.Conn
-					err  error
-				}{
-					conn: conn,
-					err:  err,
-				}:
-					return
-				case <-dctx.Done():
-					if err == nil && conn != nil {
-						_ = conn.Close()
-					}
-					return
-				}
-			}()
-
-			select {
-			case <-dctx.Done():
-				if cancel != nil {
-					cancel()
-				}
-				return nil, dctx.Err()
-			case r := <-ch:
-				if cancel != nil {
-					cancel()
-				}
-				return r.conn, r.err
-			}
+// proxyHost returns the host of a proxy address, without port or IPv6 brackets
+func proxyHost(proxyAddr string) string {
+	host := proxyAddr
+	if strings.Contains(host, "://") {
+		u, _ := url.Parse(host)
+		if u != nil {
+			host = u.Host
 		}
-
-	default:
-		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
 	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
 
-	return transport, nil
+// newTransport builds the library transport for proxyAddr with the run's dialer
+func newTransport(proxyAddr string, dialTimeout float64, insecure bool, maxHeaderBytes int64) (*http.Transport, error) {
+	return proxyra.NewTransport(proxyAddr, proxyra.TransportOptions{
+		DialTimeout:    time.Duration(dialTimeout * float64(time.Second)),
+		Insecure:       insecure,
+		MaxHeaderBytes: maxHeaderBytes,
+		Dial:           proxyDial(),
+		DefaultScheme:  defaultScheme,
+	})
 }
 
 // check if proxy works with TCP mode
 func checkProxyTCP(proxyAddr, target string, timeout float64) bool {
-	// accept scheme-less proxy like "1.2.3.4:1080" and default to socks5
-	if !strings.Contains(proxyAddr, "://") {
-		proxyAddr = "socks5://" + proxyAddr
-	}
-
-	u, err := url.Parse(proxyAddr)
+	conn, err := dialTunnel(proxyAddr, target, timeout)
 	if err != nil {
 		return false
 	}
+	conn.Close()
+	return true
+}
 
-	var conn net.Conn
-	timeoutDuration := time.Duration(timeout * float64(time.Second))
-
-	switch u.Scheme {
-	case "socks4", "socks4a", "socks5":
-		dialSocks := socks.Dial(proxyAddr)
-		ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
-		defer cancel()
-
-		ch := make(chan struct {
-			conn net.Conn
-			err  error
-		}, 1)
-
-		go func() {
-			c, e := dialSocks("tcp", target)
-			select {
-			case ch <- struct {
-				conn net.Conn
-				err  error
-			}{conn: c, err: e}:
-			case <-ctx.Done():
-				if e == nil && c != nil {
-					c.Close()
-				}
-			}
-		}()
-
-		select {
-		case <-ctx.Done():
-			return false
-		case r := <-ch:
-			if r.err != nil {
-				return false
-			}
-			conn = r.conn
-		}
+// dialTunnel opens a raw TCP tunnel to target through the proxy: a direct
+// SOCKS dial, or CONNECT for HTTP proxies
+func dialTunnel(proxyAddr, target string, timeout float64) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout*float64(time.Second)))
+	defer cancel()
+	return proxyra.DialTunnel(ctx, proxyAddr, target, proxyra.TransportOptions{Dial: proxyDial(), DefaultScheme: defaultScheme})
+}
 
-	case "http", "https":
-		proxyConn, err := net.DialTimeout("tcp", u.Host, timeoutDuration)
-		if err != nil {
-			return false
-		}
+// checkOptions holds the settings shared by every check in a run
+type checkOptions struct {
+	target         string
+	timeout        float64
+	connectTimeout float64 // dial phase only, with -connect-timeout; 0 means timeout
+	re             *regexp.Regexp
+	suite          []suiteRow                 // replaces target and re when -suite or -urls is set
+	matchPolicy    string                     // matchAll or matchAny with -urls; empty for -suite, which checks every row
+	fallbacks      []string                   // tried in order when target answers but fails its conditions
+	samples        int                        // latency samples taken per valid proxy, with -samples
+	maxJitter      time.Duration              // drop valid proxies whose sampled jitter is higher (0 = no limit)
+	strictScheme   bool                       // fail scheme-less proxies instead of assuming -default-scheme
+	autoSchemes    []string                   // schemes tried in order on scheme-less proxies, nil unless -auto-scheme
+	geo            *mmdbLookup[countryRecord] // nil unless -geoip-db
+	asn            *mmdbLookup[asnRecord]     // nil unless -asn-db
+	excludeASN     asnSet                     // AS numbers whose proxies are dropped
+	countries      countrySet                 // with -country, the only countries whose proxies are kept
+	chainSocks     *url.URL                   // SOCKS5 next hop behind each HTTP proxy, with -chain-socks
+	errorsOnly     bool                       // drop infof lines, keeping warnings and errors
+	quiet          bool                       // drop logf lines too, with -quiet
+	cacheURL       string                     // query-echoing endpoint for -detect-cache, empty = off
+	idleProbeMax   time.Duration              // longest idle wait for -probe-keepalive-idle, 0 = off
+	headFirst      bool                       // send HEAD, since only the status is checked
+	method         string                     // request method of the checks, -method
+	body           []byte                     // request body from -data or -data-file, nil = none
+	detectSoftware bool                       // guess HTTP proxy software from its own error page
+	bodyHash       string                     // SHA-256 of the direct fetch of target, with -verify-hash
+	ignoreRe       *regexp.Regexp             // masks volatile body sections before hashing
+	keepModified   bool                       // flag modified bodies instead of failing them, with -flag-modified
+	budget         time.Duration              // total time allowed per proxy, 0 = no limit
+	deadline       time.Time                  // end of the current proxy's budget, set by forProxy
+	assigned       map[string]string          // per-proxy target, with -round-robin-targets
+	insecure       bool
+	checkCount     int
+	tcpMode        bool
+	policy         successPolicy
+	headers        []string
+	userAgents     []string // from -user-agent or -user-agent-file; empty = Go's default
+	probeLocation  bool
+	noFollow       bool // check the first response, never following a redirect
+	maxRedirects   int  // redirects followed before giving up
+	redirectPass   bool // past maxRedirects, check the last response instead of failing
+	maxHeaderBytes int64
+	readLimit      int64 // bytes of each response body read for matching and hashing
+	detectSSLStrip bool
+	alpn           []string            // protocols offered to https targets, in preference order; nil = Go's default
+	http2          bool                // offer h2 to https targets alongside http/1.1
+	retry          proxyra.RetryPolicy // consulted after a failed request, nil = no retries
+	limiter        *rate.Limiter       // paces requests to the target across workers, nil unless -rps
+	jitter         time.Duration       // longest random pause before each attempt, 0 unless -jitter
+	urlTemplate    *template.Template
+	rng            *rand.Rand
+	seed           uint64 // -seed; when set each proxy gets its own source, see forProxy
+	drainBody      bool
+	speedTest      bool    // download the whole body and report its rate
+	minSpeed       float64 // slowest acceptable -speed-test rate in KB/s, 0 = any
+	ja3URL         string
+	ja3Baseline    string
+	judgeURL       string // header-echo endpoint, set with -judge
+	realIP         string // this machine's public IP, compared against the judge's echo and exit IPs
+	expectIPChange bool   // fail proxies whose exit IP is realIP or unknown
+	connProbeMax   int
+	ipEchoURL      string
+	needExitIP     bool             // look up the exit IP of every valid proxy
+	exitLimit      *exitLimiter     // nil unless -max-concurrent-per-exit
+	hostLimit      *hostLimiter     // nil unless -per-host-limit
+	tracer         *wireTracer      // nil unless -trace-sample
+	dnsProxyHost   string           // host:port only the proxy can resolve, set with -dns-over-proxy
+	dnsLocalHost   string           // host:port only this machine can resolve
+	leakServer     *leakServer      // nil unless -dns-leak-test
+	localResolvers map[string]bool  // resolver IPs that query the leak zone on our behalf
+	baselines      map[string]int64 // per-scheme latency baselines, nil unless -normalize-latency
+	stats          *runStats
+	gate           *pauseGate   // nil unless -control is set
+	onResult       func(Result) // called with the final result of every checked proxy
+	stderrMutex    *sync.Mutex
+	ctx            context.Context // canceled on the first interrupt, ending in-flight requests
+}
 
-		connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
-		proxyConn.SetDeadline(time.Now().Add(timeoutDuration))
-		_, err = proxyConn.Write([]byte(connectReq))
-		if err != nil {
-			proxyConn.Close()
-			return false
-		}
+// logf writes a diagnostic line to stderr without interleaving with other workers
+func (o *checkOptions) logf(format string, args ...any) {
+	if o.quiet {
+		return
+	}
+	o.stderrMutex.Lock()
+	fmt.Fprintf(os.Stderr, format, args...)
+	o.stderrMutex.Unlock()
+}
 
-		br := bufio.NewReader(proxyConn)
-		line, err := br.ReadString('\n')
-		if err != nil {
-			proxyConn.Close()
-			return false
-		}
+// forProxy returns the options for checking proxyAddr. With a
+// -per-proxy-budget that is a copy carrying the proxy's deadline, and with
+// -seed a copy whose random source is derived from the seed and the proxy,
+// so the User-Agent and template values a proxy gets do not depend on which
+// worker drew first. Otherwise it is o itself.
+func (o *checkOptions) forProxy(proxyAddr string) *checkOptions {
+	if o.budget <= 0 && o.seed == 0 {
+		return o
+	}
+	c := *o
+	if o.budget > 0 {
+		c.deadline = time.Now().Add(o.budget)
+	}
+	if o.seed != 0 {
+		h := fnv.New64a()
+		_, _ = io.WriteString(h, proxyAddr)
+		c.rng = rand.New(&lockedSource{src: rand.NewPCG(o.seed, h.Sum64())})
+	}
+	return &c
+}
 
-		// Parse HTTP status line properly
-		parts := strings.Fields(line)
-		if len(parts) < 2 || (parts[1] != "200" && !strings.HasPrefix(parts[1], "2")) {
-			proxyConn.Close()
-			return false
-		}
+// requestTimeout is the timeout for the next request: -t, cut short by
+// whatever is left of the proxy's budget
+func (o *checkOptions) requestTimeout() time.Duration {
+	d := time.Duration(o.timeout * float64(time.Second))
+	if !o.deadline.IsZero() {
+		d = min(d, time.Until(o.deadline))
+	}
+	return d
+}
 
-		// read until empty line (end of headers)
-		for {
-			line, err = br.ReadString('\n')
-			if err != nil {
-				proxyConn.Close()
-				return false
-			}
-			if line == "\r\n" || line == "\n" {
-				break
-			}
-		}
+// dialTimeout is the timeout in seconds for connecting to the proxy:
+// -connect-timeout, defaulting to -t
+func (o *checkOptions) dialTimeout() float64 {
+	if o.connectTimeout > 0 {
+		return o.connectTimeout
+	}
+	return o.timeout
+}
 
-		conn = proxyConn
+// waitTurn blocks until -rps allows another request to the target, or ctx ends
+func (o *checkOptions) waitTurn(ctx context.Context) error {
+	if o.limiter == nil {
+		return nil
+	}
+	return o.limiter.Wait(ctx)
+}
 
-	default:
-		return false
+// pause sleeps for a random time up to -jitter before an attempt, drawn
+// from rng so -seed repeats it, or less if ctx ends first
+func (o *checkOptions) pause(ctx context.Context) error {
+	if o.jitter <= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(o.rng.Int64N(int64(o.jitter) + 1)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	if conn != nil {
-		conn.Close()
-		return true
+// budgetSpent reports whether the proxy's budget has run out
+func (o *checkOptions) budgetSpent() bool {
+	return !o.deadline.IsZero() && !time.Now().Before(o.deadline)
+}
+
+// infof is logf for progress and informational lines, which -quiet-errors-only drops
+func (o *checkOptions) infof(format string, args ...any) {
+	if !o.errorsOnly {
+		o.logf(format, args...)
 	}
-	return false
 }
 
 // check if proxy works with HTTP mode
-func checkProxyHTTP(proxyAddr, target string, timeout float64, re *regexp.Regexp, insecure bool, expectedStatus int, headers []string, stderrMutex *sync.Mutex) bool {
+func checkProxyHTTP(proxyAddr string, opts *checkOptions) Result {
+	if opts.suite != nil {
+		return checkSuite(proxyAddr, opts)
+	}
+
+	t, assigned := opts.assigned[proxyAddr]
+
 	// If target is "SMART_MODE", we try multiple IP services sequentially
-	if target == "SMART_MODE" {
+	if opts.target == "SMART_MODE" && !assigned {
 		services := []string{
 			"http://icanhazip.com",
 			"https://checkip.amazonaws.com",
@@ -299,82 +413,217 @@ func checkProxyHTTP(proxyAddr, target string, timeout float64, re *regexp.Regexp
 		}
 
 		// Determine expected IP once
-		host := proxyAddr
-		if strings.Contains(host, "://") {
-			u, _ := url.Parse(host)
-			if u != nil {
-				host = u.Host
-			}
-		}
-		ip, _, err := net.SplitHostPort(host)
-		if err != nil {
-			ip = host
-		}
+		ip := proxyHost(proxyAddr)
 		ipRe, _ := regexp.Compile(regexp.QuoteMeta(strings.TrimSpace(ip)))
 
+		var res Result
 		for _, svc := range services {
-			if performHTTPCheck(proxyAddr, svc, timeout, ipRe, insecure, expectedStatus, headers, stderrMutex) {
-				return true
+			if res = performHTTPCheck(proxyAddr, svc, ipRe, opts); res.OK {
+				return res
 			}
 		}
-		return false
+		return res
+	}
+
+	target := opts.target
+	if assigned {
+		target = t
+	}
+	if opts.urlTemplate != nil {
+		var err error
+		if target, err = renderTarget(opts.urlTemplate, proxyAddr, opts.rng); err != nil {
+			return Result{Proxy: proxyAddr}
+		}
 	}
 
-	return performHTTPCheck(proxyAddr, target, timeout, re, insecure, expectedStatus, headers, stderrMutex)
+	res := performHTTPCheck(proxyAddr, target, opts.re, opts)
+	// A bad status or body means the proxy works but the target rejected it,
+	// so give it the alternates; network failures would fail those too
+	for _, fb := range opts.fallbacks {
+		if res.OK || (res.Reason != reasonBadStatus && res.Reason != reasonNoMatch) {
+			break
+		}
+		target = fb
+		res = performHTTPCheck(proxyAddr, target, opts.re, opts)
+	}
+	if (res.OK && len(opts.fallbacks) > 0) || assigned {
+		res.Target = target
+	}
+	if opts.probeLocation && res.Location != "" {
+		loc := performHTTPCheck(proxyAddr, res.Location, opts.re, opts)
+		res.LocationCheck = &probeOutcome{OK: loc.OK, Reason: loc.Reason, StatusCode: loc.StatusCode, LatencyMs: loc.LatencyMs}
+		opts.infof("Location probe: %s %s -> %s, %s -> %s\n", proxyAddr, target, outcome(res.OK), res.Location, outcome(loc.OK))
+	}
+	return res
 }
 
-func performHTTPCheck(proxyAddr, target string, timeout float64, re *regexp.Regexp, insecure bool, expectedStatus int, headers []string, stderrMutex *sync.Mutex) bool {
-	timeoutDuration := time.Duration(timeout * float64(time.Second))
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
-	defer cancel()
+func outcome(ok bool) string {
+	if ok {
+		return "OK"
+	}
+	return "FAIL"
+}
 
-	transport, err := newTransport(proxyAddr, timeout, insecure)
-	if err != nil {
-		return false
+// performHTTPCheck runs a single request through the proxy. When probeLocation
+// is set, redirects are not followed and the resolved Location is recorded.
+// The request itself is the library Checker's; the CLI's conditions and
+// probes run on its response.
+func performHTTPCheck(proxyAddr, target string, re *regexp.Regexp, opts *checkOptions) Result {
+	res := Result{Proxy: proxyAddr}
+
+	// queueing for -per-host-limit and -rps comes before the timeout starts,
+	// so it costs the proxy nothing; the host slot is held until the body is read
+	if opts.hostLimit != nil {
+		release, err := opts.hostLimit.acquire(opts.ctx, target)
+		if err != nil {
+			res.Reason = proxyra.ErrorReason(err)
+			return res
+		}
+		defer release()
+	}
+	timeout := opts.requestTimeout()
+	if timeout <= 0 {
+		res.Reason = reasonTimeout
+		return res
 	}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   timeoutDuration,
+	method := opts.method
+	if opts.headFirst {
+		method = http.MethodHead
+	}
+	header := requestHeader(opts)
+	if opts.body != nil && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	tracing := opts.tracer != nil && opts.tracer.sample(proxyAddr)
+
+	checker, err := proxyra.New(proxyra.Options{
+		Target:  target,
+		Method:  method,
+		Body:    opts.body,
+		Header:  header,
+		Timeout: timeout,
+		Retry:   opts.retry,
+		Wait:    opts.waitTurn,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
+			if opts.probeLocation || opts.noFollow {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > opts.maxRedirects {
+				if opts.redirectPass {
+					return http.ErrUseLastResponse
+				}
+				return fmt.Errorf("stopped after %d redirects: %w", opts.maxRedirects, errTooManyRedirects)
 			}
 			return nil
 		},
+		Transport: func(proxyAddr string) (http.RoundTripper, error) {
+			// file:// and data: targets are read directly, bypassing the proxy
+			if isLocalTarget(target) {
+				return localTransport{}, nil
+			}
+			t, err := newTransport(proxyAddr, opts.dialTimeout(), opts.insecure, opts.maxHeaderBytes)
+			if err != nil {
+				return nil, err
+			}
+			if opts.chainSocks != nil {
+				chainThroughSOCKS(t, proxyAddr, opts.chainSocks, opts.timeout)
+			}
+			if opts.alpn != nil {
+				offerALPN(t, opts.alpn)
+			} else if opts.http2 {
+				t.ForceAttemptHTTP2 = true
+			}
+			return t, nil
+		},
+		Evaluate: func(resp *http.Response, r *proxyra.Result) {
+			res.LatencyMs = r.Latency.Milliseconds()
+			res.DialMs = r.Dial.Milliseconds()
+			evaluateResponse(&res, resp, target, re, opts, tracing)
+			r.OK, r.Reason = res.OK, res.Reason
+		},
+	})
+	if err != nil {
+		return res
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	checked, err := checker.Check(opts.ctx, proxyAddr)
 	if err != nil {
-		return false
+		return res
 	}
-
-	// Add custom headers
-	for _, h := range headers {
-		parts := strings.SplitN(h, ":", 2)
-		if len(parts) != 2 {
-			stderrMutex.Lock()
-			fmt.Fprintf(os.Stderr, "Warning: ignoring malformed header: %s\n", h)
-			stderrMutex.Unlock()
-			continue
+	if checked.Err != nil {
+		if tracing {
+			traced, _ := http.NewRequest(method, target, nil)
+			if traced != nil {
+				traced.Header = header
+				reqDump, _ := httputil.DumpRequestOut(traced, false)
+				opts.tracer.dump(proxyAddr, reqDump, nil, checked.Err)
+			}
+		}
+		res.Reason = checked.Reason
+		var urlErr *url.Error
+		if errors.Is(checked.Err, errTooManyRedirects) && errors.As(checked.Err, &urlErr) {
+			res.Reason, res.EffectiveURL = reasonRedirects, urlErr.URL
 		}
-		req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		return res
 	}
+	res.StatusCode = checked.StatusCode
+	res.LatencyMs = checked.Latency.Milliseconds()
+	res.DialMs = checked.Dial.Milliseconds()
+	res.OK, res.Reason = checked.OK, checked.Reason
+	return res
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return false
+// evaluateResponse applies the CLI's conditions to the response of a check
+// and records what the probes found
+func evaluateResponse(res *Result, resp *http.Response, target string, re *regexp.Regexp, opts *checkOptions, tracing bool) {
+	res.EffectiveURL = resp.Request.URL.String()
+	res.StatusCode = resp.StatusCode
+	// the request the check sent, before any redirects
+	req := resp.Request
+	for req.Response != nil {
+		req = req.Response.Request
 	}
-	defer resp.Body.Close()
 
-	// Check expected status code if specified
-	if expectedStatus > 0 && resp.StatusCode != expectedStatus {
-		return false
+	// An https:// request answered without TLS means the proxy downgraded it
+	if opts.detectSSLStrip && req.URL.Scheme == "https" && resp.TLS == nil {
+		res.Reason = reasonSSLStrip
+		return
 	}
 
-	// Read body up to limit
+	if resp.TLS != nil {
+		res.ALPN = resp.TLS.NegotiatedProtocol
+	}
+	if opts.http2 {
+		res.Proto = resp.Proto
+	}
+	if opts.alpn != nil && !slices.Contains(opts.alpn, res.ALPN) {
+		res.Reason = reasonALPN
+		return
+	}
+
+	if opts.probeLocation && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if loc, err := resp.Location(); err == nil {
+			res.Location = loc.String()
+		}
+	}
+
+	// header conditions need no body, so a miss ends the check before reading it
+	if !opts.policy.headersMatch(resp.Header) {
+		res.Failed = []string{condHeader}
+		res.Reason = reasonHeaderMismatch
+		return
+	}
+
+	// Read body up to limit; the limit counts decompressed bytes
 	var buf bytes.Buffer
-	_, _ = io.CopyN(&buf, resp.Body, int64(readLimitBytes))
+	bodyStart := time.Now()
+	bodyReader := decodedBody(resp)
+	n, _ := io.CopyN(&buf, bodyReader, opts.readLimit)
+	if opts.speedTest {
+		// only what is kept for matching is buffered; the rest is counted and dropped
+		rest, _ := io.CopyN(io.Discard, bodyReader, speedTestLimitBytes-n)
+		res.SpeedKBps = int64(kbPerSecond(n+rest, time.Since(bodyStart)))
+	}
 
 	// Dump headers (false = do not dump body yet)
 	headerDump, err := httputil.DumpResponse(resp, false)
@@ -385,43 +634,331 @@ func performHTTPCheck(proxyAddr, target string, timeout float64, re *regexp.Rege
 	var fullResponse bytes.Buffer
 	fullResponse.Write(headerDump)
 	fullResponse.Write(buf.Bytes())
+	if tracing {
+		reqDump, _ := httputil.DumpRequestOut(req, false)
+		opts.tracer.dump(res.Proxy, reqDump, fullResponse.Bytes(), nil)
+	}
+
+	dial := time.Duration(res.DialMs) * time.Millisecond
+	res.Failed = opts.policy.evaluate(resp.StatusCode, time.Duration(res.LatencyMs)*time.Millisecond, dial, fullResponse.Bytes(), re)
+	if opts.bodyHash != "" && target == opts.target && bodyDigest(buf.Bytes(), opts.ignoreRe) != opts.bodyHash {
+		res.Modified = true
+		if !opts.keepModified {
+			res.Failed = append(res.Failed, condHash)
+		}
+	}
+	if opts.speedTest && opts.minSpeed > 0 && float64(res.SpeedKBps) < opts.minSpeed {
+		res.Failed = append(res.Failed, condSpeed)
+	}
+	res.OK = len(res.Failed) == 0
+	if !res.OK {
+		res.Reason = conditionReason(res.Failed[0])
+	}
+
+	// Read the rest so the server sees a complete request; bounded by the cap and the timeout
+	if opts.drainBody {
+		_, _ = io.CopyN(io.Discard, resp.Body, drainLimitBytes)
+	}
+}
+
+// requestHeader holds the -user-agent, drawn at random when there are
+// several, and then the custom -H headers, which win over it. main has
+// already rejected headers without a colon.
+func requestHeader(opts *checkOptions) http.Header {
+	h := http.Header{}
+	if n := len(opts.userAgents); n > 0 {
+		h.Set("User-Agent", opts.userAgents[opts.rng.IntN(n)])
+	}
+	for _, line := range opts.headers {
+		key, value, _ := strings.Cut(line, ":")
+		h.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return h
+}
+
+// applyHeaders sets requestHeader on req
+func applyHeaders(req *http.Request, opts *checkOptions) {
+	for key, values := range requestHeader(opts) {
+		req.Header[key] = values
+	}
+}
+
+// httpMethodRe accepts the -method values worth sending: upper-case tokens
+var httpMethodRe = regexp.MustCompile(`^[A-Z]+$`)
+
+// checkHeader rejects a -H value that is not "Key: Value"
+func checkHeader(h string) error {
+	key, _, ok := strings.Cut(h, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("malformed header %q, want \"Key: Value\"", h)
+	}
+	return nil
+}
+
+// fetchBody GETs target through proxyAddr, or directly when proxyAddr is empty,
+// and returns the response with up to -read-limit bytes of its body
+func fetchBody(proxyAddr, target string, opts *checkOptions) (*http.Response, []byte, error) {
+	timeoutDuration := opts.requestTimeout()
+	ctx, cancel := context.WithTimeout(opts.ctx, timeoutDuration)
+	defer cancel()
+
+	var transport http.RoundTripper = localTransport{}
+	if !isLocalTarget(target) {
+		t, err := newTransport(proxyAddr, opts.dialTimeout(), opts.insecure, opts.maxHeaderBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		transport = t
+	}
+	client := &http.Client{Transport: transport, Timeout: timeoutDuration}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyHeaders(req, opts)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(decodedBody(resp), opts.readLimit))
+	return resp, body, err
+}
 
-	transport.CloseIdleConnections()
+// probeAlive runs the optional extra probes on a proxy that passed its checks
+func probeAlive(res *Result, proxyAddr string, opts *checkOptions) {
+	if opts.baselines != nil {
+		normalizeLatency(res, opts.baselines)
+	}
+	if opts.needExitIP {
+		if ip, err := fetchExitIP(proxyAddr, opts); err == nil {
+			res.ExitIP = ip
+		}
+	}
+	if opts.connProbeMax > 0 {
+		res.MaxConns = probeMaxConns(proxyAddr, tunnelTarget(opts), opts.connProbeMax, opts.timeout)
+		opts.infof("Conn probe: %s held %d concurrent tunnels (cap %d)\n", proxyAddr, res.MaxConns, opts.connProbeMax)
+	}
+	if opts.idleProbeMax > 0 {
+		if held, closed, err := probeIdleTimeout(proxyAddr, tunnelTarget(opts), opts.idleProbeMax, opts.timeout); err == nil {
+			res.IdleHeldMs, res.IdleClosed = held.Milliseconds(), closed
+			opts.infof("Idle probe: %s held an idle tunnel %s (closed: %v, cap %s)\n", proxyAddr, held, closed, opts.idleProbeMax)
+		}
+	}
+	if opts.dnsProxyHost != "" {
+		res.DNS = classifyProxyDNS(proxyAddr, opts)
+	}
+	if opts.leakServer != nil {
+		res.DNSLeak = checkDNSLeak(proxyAddr, opts)
+	}
+	if opts.detectSoftware {
+		res.Software = detectSoftware(proxyAddr, opts)
+	}
+	if opts.judgeURL != "" {
+		res.Anonymity = judgeAnonymity(proxyAddr, opts)
+	}
+	if opts.cacheURL != "" {
+		res.Cache = checkCaching(proxyAddr, opts)
+		if res.Cache == cacheCaching {
+			opts.logf("Warning: %s served a cached response to a cache-busting request\n", proxyAddr)
+		}
+	}
+	if opts.ja3URL != "" {
+		if ja3, err := fetchJA3(proxyAddr, opts); err == nil {
+			res.JA3 = ja3
+			res.JA3Changed = opts.ja3Baseline != "" && ja3 != opts.ja3Baseline
+			if res.JA3Changed {
+				opts.logf("Warning: %s rewrote the TLS ClientHello (JA3 %s, direct %s)\n", proxyAddr, ja3, opts.ja3Baseline)
+			}
+		}
+	}
+}
 
-	return re.Match(fullResponse.Bytes())
+// backoffPolicy maps a -reset-backoff or -retry-backoff name onto its policy
+func backoffPolicy(backoff string, delay time.Duration, attempts int, rng *rand.Rand) proxyra.RetryPolicy {
+	switch backoff {
+	case "linear":
+		return proxyra.LinearBackoff{Step: delay, Attempts: attempts}
+	case "exponential":
+		return proxyra.ExponentialBackoff{Base: delay, Attempts: attempts}
+	case "jittered":
+		return proxyra.JitteredBackoff{ExponentialBackoff: proxyra.ExponentialBackoff{Base: delay, Attempts: attempts}, Rand: rng}
+	default:
+		return proxyra.ConstantBackoff{Delay: delay, Attempts: attempts}
+	}
+}
+
+// requestPolicy combines the -reconnect-on-reset and -retries policies, either
+// of which may be nil: resets go to the first and other connection or timeout
+// errors to the second. It returns nil when both are off.
+func requestPolicy(resets, transient proxyra.RetryPolicy) proxyra.RetryPolicy {
+	if resets == nil && transient == nil {
+		return nil
+	}
+	return proxyra.RetryFunc(func(attempt int, err error) (time.Duration, bool) {
+		switch {
+		case resets != nil && isConnReset(err):
+			return resets.NextDelay(attempt, err)
+		case transient != nil && isTransient(err):
+			return transient.NextDelay(attempt, err)
+		}
+		return 0, false
+	})
+}
+
+// isTransient reports whether a failed request may succeed when tried again:
+// dial and connection errors, timeouts and connections closed early. Errors
+// such as oversized headers would only repeat.
+func isTransient(err error) bool {
+	var opErr *net.OpError
+	var netErr net.Error
+	return errors.As(err, &opErr) ||
+		errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		isConnReset(err)
+}
+
+// isConnReset reports whether err is a connection reset or abort by the peer
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED)
+}
+
+// checkOnce runs one check of the proxy in the configured mode
+func checkOnce(proxyAddr string, opts *checkOptions) Result {
+	if err := opts.pause(opts.ctx); err != nil {
+		return Result{Proxy: proxyAddr, Reason: proxyra.ErrorReason(err)}
+	}
+	if opts.tcpMode {
+		conn, err := dialTunnel(proxyAddr, opts.target, opts.requestTimeout().Seconds())
+		if err != nil {
+			return Result{Proxy: proxyAddr, Reason: proxyra.ErrorReason(err)}
+		}
+		conn.Close()
+		return Result{Proxy: proxyAddr, OK: true}
+	}
+	res := checkProxyHTTP(proxyAddr, opts)
+	switch res.Reason {
+	case reasonHeaderTooLarge:
+		opts.logf("Warning: %s sent response headers over %d bytes (%s)\n", proxyAddr, opts.maxHeaderBytes, res.Reason)
+	case reasonSSLStrip:
+		opts.logf("Warning: %s answered an https request without TLS (%s)\n", proxyAddr, res.Reason)
+	}
+	return res
 }
 
 // worker
-func worker(jobs <-chan string, target string, timeout float64, re *regexp.Regexp, out chan<- string, wg *sync.WaitGroup, insecure bool, checkCount int, tcpMode bool, expectedStatus int, headers []string, maxFound *int, maxMutex *sync.Mutex, done chan struct{}, stderrMutex *sync.Mutex) {
+func worker(jobs <-chan string, shared *checkOptions, out chan<- Result, wg *sync.WaitGroup, maxFound *int, maxMutex *sync.Mutex, done chan struct{}) {
 	defer wg.Done()
 	for proxyAddr := range jobs {
-		// Check if we should stop early
+		if shared.gate != nil {
+			shared.gate.wait(shared.ctx)
+		}
+
+		// Check if we should stop early, including after a pause
 		select {
 		case <-done:
 			return
+		case <-shared.ctx.Done():
+			return
 		default:
 		}
 
+		opts := shared.forProxy(proxyAddr)
+
+		if opts.strictScheme && !strings.Contains(proxyAddr, "://") {
+			opts.logf("Error: %s has no scheme; -strict-scheme requires e.g. http:// or socks5://\n", proxyAddr)
+			opts.stats.checked.Add(1)
+			if opts.onResult != nil {
+				opts.onResult(Result{Proxy: proxyAddr, Reason: reasonNoScheme})
+			}
+			continue
+		}
+
+		release := func() {}
+		if opts.exitLimit != nil {
+			release = opts.exitLimit.acquire(proxyAddr)
+		}
+
 		passed := 0
-		for i := 0; i < checkCount; i++ {
-			var success bool
-			if tcpMode {
-				success = checkProxyTCP(proxyAddr, target, timeout)
+		var res Result
+		for i := 0; i < opts.checkCount; i++ {
+			if i == 0 && opts.autoSchemes != nil && !strings.Contains(proxyAddr, "://") {
+				// later checks and probes use the scheme that worked
+				res = probeSchemes(proxyAddr, opts)
+				proxyAddr = res.Proxy
 			} else {
-				success = checkProxyHTTP(proxyAddr, target, timeout, re, insecure, expectedStatus, headers, stderrMutex)
+				res = checkOnce(proxyAddr, opts)
 			}
-			if success {
+			if res.OK {
 				passed++
-			} else if checkCount > 1 {
+			} else if opts.checkCount > 1 {
 				// Early exit: if we need all checks to pass and one failed, no point continuing
 				break
 			}
 		}
-		if passed == checkCount {
+		res.Scheme = proxyScheme(proxyAddr)
+		alive := passed == opts.checkCount
+		if !alive && opts.budgetSpent() {
+			res.Reason = reasonBudget
+		}
+		if alive && opts.samples > 1 {
+			sampleLatency(&res, proxyAddr, opts.samples, opts)
+			if tooJittery(&res, opts.maxJitter) {
+				res.OK, res.Reason = false, reasonJitter
+				alive = false
+			}
+		}
+		if alive {
+			probeAlive(&res, proxyAddr, opts)
+			if opts.exitLimit != nil {
+				opts.exitLimit.learn(proxyAddr, res.ExitIP)
+			}
+			if opts.expectIPChange && (res.ExitIP == "" || res.ExitIP == opts.realIP) {
+				res.OK, res.Reason = false, reasonIPUnchanged
+				alive = false
+			}
+			if opts.geo != nil {
+				res.Country = opts.geo.lookup(countryIP(&res, opts)).Country.ISOCode
+				if res.Country == "" {
+					res.Country = countryUnknown
+				}
+				if len(opts.countries) > 0 && !opts.countries[res.Country] {
+					res.OK, res.Reason = false, reasonCountry
+					alive = false
+				}
+			}
+			if opts.asn != nil {
+				rec := opts.asn.lookup(geoIP(&res))
+				res.ASN, res.ASOrg = rec.Number, rec.Org
+				if opts.excludeASN[rec.Number] {
+					res.OK, res.Reason = false, reasonExcludedASN
+					alive = false
+				}
+			}
+		}
+		release()
+		if opts.ctx.Err() != nil {
+			// cut short by an interrupt: the proxy was not really checked
+			return
+		}
+		opts.stats.checked.Add(1)
+		if alive {
+			opts.stats.passed.Add(1)
+			opts.stats.latencyMs.Add(res.LatencyMs)
+		}
+		if opts.onResult != nil {
+			opts.onResult(res)
+		}
+		if alive {
 			if maxFound != nil {
 				maxMutex.Lock()
 				if *maxFound > 0 {
-					out <- proxyAddr
+					out <- res
 					*maxFound--
 					if *maxFound == 0 {
 						// Signal completion using sync.Once pattern
@@ -435,7 +972,7 @@ func worker(jobs <-chan string, target string, timeout float64, re *regexp.Regex
 				}
 				maxMutex.Unlock()
 			} else {
-				out <- proxyAddr
+				out <- res
 			}
 		}
 	}
@@ -452,21 +989,262 @@ func (h *headerFlags) Set(value string) error {
 	return nil
 }
 
-func main() {
-	target := flag.String("u", "", "Target URL or address (required if -tcp is used)")
-	timeout := flag.Float64("t", 5.0, "Timeout in seconds (float, e.g. 1.5)")
-	threads := flag.Int("c", 10, "Concurrency (number of threads)")
-	listFile := flag.String("l", "", "File with list of proxies")
-	regexStr := flag.String("r", "", "Regex to match response (headers or body)")
+// secondsFlag is a timeout flag in seconds that takes a plain number, as it
+// always has (5, 1.5), or a Go duration such as 750ms or 2s
+type secondsFlag float64
+
+func (s *secondsFlag) String() string {
+	return strconv.FormatFloat(float64(*s), 'f', -1, 64)
+}
+
+func (s *secondsFlag) Set(value string) error {
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		*s = secondsFlag(secs)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("want seconds (1.5) or a duration (750ms): %q", value)
+	}
+	*s = secondsFlag(d.Seconds())
+	return nil
+}
+
+func main() {
+	target := flag.String("u", "", "Target URL or address (required if -tcp is used)")
+	timeout, connectTimeout := new(float64), new(float64)
+	*timeout = 5
+	flag.Var((*secondsFlag)(timeout), "t", "Timeout in seconds (float, e.g. 1.5) or as a duration (e.g. 750ms)")
+	flag.Var((*secondsFlag)(connectTimeout), "connect-timeout", "Timeout for connecting to the proxy, in seconds or as a duration, leaving -t for the whole request (default: -t)")
+	threads := flag.Int("c", 10, "Concurrency (number of threads)")
+	listFile := flag.String("l", "", "File with list of proxies")
+	var listURLs headerFlags
+	flag.Var(&listURLs, "list-url", "Download a list of proxies from this URL at startup, directly rather than through a proxy (repeatable; added to -l)")
+	regexStr := flag.String("r", "", "Regex to match response (headers or body)")
+	var headerRegexes headerPatterns
+	flag.Var(&headerRegexes, "header-regex", "Response header that must match, as \"Name: pattern\", e.g. \"Server: ^nginx\" (repeatable; all must match)")
+	excludeRegex := flag.String("exclude-regex", "", "Fail proxies whose response (headers or body) matches this regex, even when -r matches, e.g. a captive portal or block page")
 	insecure := flag.Bool("k", false, "Allow insecure TLS connections (disabled by default)")
+	verifyTLS := flag.Bool("verify-tls", false, "Require valid target certificates (the default unless -k); failures are reported as tls_verify_failed")
 	checkCount := flag.Int("n", 1, "Number of times a proxy must pass checks to be valid")
 	tcpMode := flag.Bool("tcp", false, "TCP connection mode (test raw TCP connection instead of HTTP)")
 	maxFound := flag.Int("m", 0, "Stop after finding N valid proxies (0 = unlimited)")
 	expectedStatus := flag.Int("s", 0, "Expected HTTP status code (0 = any status)")
+	noFollow := flag.Bool("no-follow", false, "Do not follow redirects: check the status and body of the first response")
+	maxRedirects := flag.Int("max-redirects", 10, "Follow at most N redirects; a longer chain fails as too_many_redirects")
+	redirectPass := flag.Bool("redirect-as-success", false, "Past -max-redirects, check the last response reached instead of failing")
+	probeLocation := flag.Bool("probe-location", false, "On a redirect, also check the Location target through the same proxy and report both outcomes")
+	readLimitFlag := flag.String("read-limit", "64KB", "Bytes of each response body read for -r and the other body checks, e.g. 512KB or 2M; 0 reads the whole body, up to 16MB")
+	maxHeaderBytes := flag.Int64("max-header-bytes", defaultMaxHeaderBytes, "Maximum response header size in bytes; larger responses fail as header_too_large")
+	http2 := flag.Bool("http2", false, "Offer HTTP/2 to https targets, using it where the target agrees, and show the HTTP version of each response with -v")
+	alpnList := flag.String("alpn", "", "Offer these ALPN protocols to https targets, e.g. h2,http/1.1, and fail proxies that negotiate none of them")
+	detectSSLStrip := flag.Bool("detect-ssl-strip", false, "Fail proxies that answer an https:// target without TLS (requires an https -u)")
+	resetRetries := flag.Int("reconnect-on-reset", 0, "Retry a request up to N times (max 5) when the connection is reset")
+	resetBackoff := flag.String("reset-backoff", "constant", "Wait between -reconnect-on-reset retries: constant, exponential or jittered")
+	jitter := flag.Duration("jitter", 0, "Wait a random time up to this before each check attempt, e.g. 500ms, so dials do not all start together (0 = off)")
+	rps := flag.Float64("rps", 0, "Send at most N requests per second to the target, shared by all workers (fractions allowed; 0 = no limit)")
+	retries := flag.Int("retries", 0, "Retry a request up to N times (max 10) on connection and timeout errors, within the -t timeout")
+	retryBackoff := flag.String("retry-backoff", "linear", "Wait between -retries: linear or exponential")
+	retryDelay := flag.Duration("retry-delay", 200*time.Millisecond, "Base wait for -retry-backoff")
+	resetDelay := flag.Duration("reset-delay", 0, "Base wait for -reset-backoff (0 = retry immediately)")
+	jsonOutput := flag.Bool("json", false, "Print one JSON object per valid proxy (NDJSON)")
+	outputFormat := flag.String("output", "text", "Output format: text (one proxy per line), json (same as -json) or csv (proxy,scheme,status,latency_ms,country,effective_url with a header row)")
+	emitSchema := flag.Bool("emit-schema", false, "With -json, print a meta line describing the result schema first")
+	urlTemplate := flag.String("url-template", "", "Per-proxy target URL template, e.g. 'https://site/?via={{.ProxyHost}}&t={{.Rand}}' (fields: ProxyHost, ProxyPort, Rand, Timestamp)")
+	drainBody := flag.Bool("drain-body", false, "After matching, read and discard the rest of the body (up to 64 MB)")
+	showProgress := flag.Bool("progress", false, "Keep a live progress line on stderr: proxies checked out of the total and the pass rate (only when stderr is a terminal)")
+	forceProgress := flag.Bool("force-progress", false, "Show -progress even when stderr is not a terminal")
+	tuiMode := flag.Bool("tui", false, "Show a live dashboard on stderr (falls back to plain output when not a terminal)")
+	noPrivate := flag.Bool("no-private", false, "Skip proxies whose host is a literal private, loopback, link-local or reserved IP")
+	fingerprintJA3 := flag.Bool("fingerprint-ja3", false, "Report the JA3 fingerprint the -ja3-url endpoint sees through each valid proxy, flagging rewrites")
+	ja3URL := flag.String("ja3-url", defaultJA3URL, "JA3-reporting endpoint used by -fingerprint-ja3")
+	controlPath := flag.String("control", "", "Unix socket path accepting pause, resume and status commands")
+	normalizeLatency := flag.Bool("normalize-latency", false, "Subtract a per-scheme baseline, measured once through loopback stubs, from latencies (latency_norm_ms)")
+	connProbe := flag.Int("conn-probe", 0, "For each valid proxy, find how many concurrent tunnels it holds, up to N (0 = off)")
+	dnsOverProxy := flag.Bool("dns-over-proxy", false, "Classify where valid HTTP proxies resolve names (proxy, client, both, none)")
+	dnsProxyHost := flag.String("dns-proxy-host", "", "Host[:port] only the proxy side can resolve, for -dns-over-proxy")
+	dnsLocalHost := flag.String("dns-local-host", "", "Host[:port] only this machine can resolve, for -dns-over-proxy")
+	kafkaBrokers := flag.String("kafka", "", "Comma-separated Kafka brokers to publish each valid proxy to as JSON")
+	kafkaTopic := flag.String("kafka-topic", "", "Kafka topic for -kafka")
+	ipEchoURL := flag.String("ip-echo-url", defaultIPEchoURL, "Endpoint that echoes the caller's IP, used to discover exit IPs")
+	perHostLimit := flag.Int("per-host-limit", 0, "Send at most N requests at once to the same target host, across all workers (0 = no limit)")
+	maxPerExit := flag.Int("max-concurrent-per-exit", 0, "Check at most N proxies at once through the same exit IP, once that exit has been discovered (0 = no limit)")
+	dedupeExitScheme := flag.Bool("dedupe-by-exit-and-scheme", false, "Print only the first valid proxy for each (exit IP, scheme) pair; with -sort latency, the fastest")
+	warnDupExit := flag.Bool("warn-duplicate-exit", false, "Warn when a valid proxy shares its exit IP with one already printed")
+	var requireStatus statusList
+	flag.Var(&requireStatus, "require-status", "Allowed HTTP status codes, comma-separated or repeated (e.g. 200,204)")
+	flag.Var(&requireStatus, "expect-status", "Same as -require-status, e.g. -expect-status 200,204,301")
+	requireRegex := flag.String("require-regex", "", "Regex the response must match (alternative spelling of -r)")
+	requireMaxDial := flag.Duration("require-max-dial", 0, "Slowest acceptable time to connect through the proxy, including SOCKS and TLS handshakes, e.g. 300ms (0 = no limit)")
+	printStats := flag.Bool("stats", false, "When the run ends, print a summary on stderr: proxies checked, passed and failed, elapsed time and average latency")
+	showLatency := flag.Bool("show-latency", false, "In text output, print each valid proxy followed by its latency_ms (and speed_kbps with -speed-test)")
+	requireMaxLatency := flag.Duration("require-max-latency", 0, "Slowest acceptable time to response headers, e.g. 800ms (0 = no limit)")
+	checkpointPath := flag.String("checkpoint", "", "Record checked proxies in FILE and skip them when the run is restarted")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "How often -checkpoint is flushed to disk")
+	dnsLeakTest := flag.Bool("dns-leak-test", false, "Check whether valid proxies leak DNS lookups to the local resolver")
+	dnsLeakZone := flag.String("dns-leak-zone", "", "Zone delegated to this host's -dns-leak-listen server, e.g. leak.example.com")
+	dnsLeakListen := flag.String("dns-leak-listen", ":53", "UDP address of the built-in authoritative server for -dns-leak-test")
+	dnsLeakAnswer := flag.String("dns-leak-answer", "192.0.2.1", "IPv4 address returned for probe names")
+	sortBy := flag.String("sort", "", "Buffer valid proxies until the run ends and print them ordered by: latency (ascending)")
+	keepFastestPct := flag.Float64("keep-fastest-pct", 0, "Print only the fastest N percent of valid proxies; buffers all results until the run ends (0 = off)")
+	urlsPath := flag.String("urls", "", "File of target URLs, one per line, every proxy is checked against with -r and -match-policy")
+	matchPolicy := flag.String("match-policy", matchAll, "With -urls: all (every target must match) or any (one is enough)")
+	suitePath := flag.String("suite", "", "File of URL REGEX [required|optional] rows every proxy is checked against")
+	samples := flag.Int("samples", 1, "Latency samples to take from each valid proxy; reports latency_mean_ms and jitter_ms when > 1")
+	speedTest := flag.Bool("speed-test", false, "Download the whole -u response through each proxy (up to 64MB) and report its rate as speed_kbps; use a large file of known size")
+	minSpeed := flag.Float64("min-speed", 0, "With -speed-test, drop proxies slower than this many KB/s (0 = keep all)")
+	maxJitter := flag.Duration("max-jitter", 0, "With -samples, drop proxies whose latency standard deviation exceeds this, e.g. 50ms (0 = no limit)")
+	portScan := flag.Bool("port-scan", false, "Expand bare IP lines by probing -scan-ports and detecting the proxy protocol on each open port")
+	scanPorts := flag.String("scan-ports", defaultScanPorts, "Comma-separated ports tried by -port-scan")
+	protoPath := flag.String("o-proto", "", "Also write valid proxies to FILE as length-delimited protobuf Result messages (see result.proto)")
+	rotatePath := flag.String("o-rotate", "", "Also write results to FILE, rotating it by -rotate-size and -rotate-interval")
+	rotateSize := flag.String("rotate-size", "10MB", "Rotate the -o-rotate file before it grows past this size, e.g. 512KB or 10MB (0 = no limit)")
+	rotateInterval := flag.Duration("rotate-interval", 0, "Rotate the -o-rotate file after it has been open this long, e.g. 1h (0 = no limit)")
+	autoScheme := flag.Bool("auto-scheme", false, "Check scheme-less proxies as each -auto-scheme-order scheme in turn, stopping at the first that passes")
+	autoSchemeOrder := flag.String("auto-scheme-order", defaultSchemeOrder, "Schemes tried by -auto-scheme, in order")
+	strictScheme := flag.Bool("strict-scheme", false, "Reject proxies without an explicit scheme instead of defaulting to -default-scheme")
+	defaultSchemeFlag := flag.String("default-scheme", "socks5", "Scheme for proxies listed without one: http, https, socks4, socks4a or socks5")
+	geoipDB := flag.String("geoip-db", "", "MaxMind Country or City database (.mmdb) used to add the country of each valid proxy's host")
+	flag.StringVar(geoipDB, "geo-db", "", "Alias of -geoip-db")
+	countries := countrySet{}
+	flag.Var(countries, "country", "With -geoip-db, keep only proxies in these countries, ISO codes comma-separated or repeated (e.g. US,DE); unknown ones are dropped too")
+	byCountryDir := flag.String("o-by-country", "", "With -geoip-db, also write valid proxies to DIR/<CC>.txt (unknown.txt when not found)")
+	asnDB := flag.String("asn-db", "", "MaxMind ASN database (.mmdb) used to add asn and as_org to valid proxies")
+	excludeASN := asnSet{}
+	flag.Var(excludeASN, "exclude-asn", "With -asn-db, drop proxies in these AS numbers, comma-separated or repeated (e.g. AS16509,14061)")
+	via := flag.String("via", "", "Reach every proxy, and the target of direct fetches, through this upstream proxy first, e.g. http://gateway:3128 or socks5://10.0.0.1:1080")
+	chainSocks := flag.String("chain-socks", "", "Check HTTP proxies end to end through this SOCKS5 next hop, reached via CONNECT, e.g. socks5://10.0.0.2:1080")
+	verbose := flag.Bool("v", false, "Print the outcome of every checked proxy on stderr, e.g. 'PROXY FAIL timeout'")
+	quietErrors := flag.Bool("quiet-errors-only", false, "Only print warnings and errors on stderr, dropping progress and informational lines")
+	quiet := flag.Bool("quiet", false, "Print nothing on stderr but errors: no progress, stats, -v lines, dashboard or warnings, leaving only valid proxies on stdout")
+	expectIPChange := flag.Bool("expect-ip-change", false, "Fail proxies whose exit IP, from -ip-echo-url, is this machine's own public IP or cannot be read")
+	judgeURL := flag.String("judge", "", "Header-echo endpoint (e.g. https://httpbin.org/get) used to grade valid proxies as transparent, anonymous or elite")
+	detectCache := flag.Bool("detect-cache", false, "Classify valid proxies as fresh, caching or unknown using cache-busting requests to -cache-url")
+	cacheURL := flag.String("cache-url", defaultCacheURL, "Endpoint that echoes its query string, used by -detect-cache")
+	maxMemory := flag.String("max-memory", "0", "Soft cap on results buffered by -keep-fastest-pct or -sort before they spill to a temp file, e.g. 256MB (0 = no cap)")
+	idleProbe := flag.Duration("probe-keepalive-idle", 0, "For each valid proxy, measure how long an idle tunnel stays open, waiting up to this long, e.g. 2m (0 = off)")
+	traceSample := flag.Int("trace-sample", 0, "Dump the full request and response of every HTTP check for the first N proxies checked (0 = off)")
+	traceFile := flag.String("trace-file", "", "Write -trace-sample dumps to FILE instead of stderr")
+	outputPath := flag.String("o", "", "Also write results to FILE, flushed after every result by default; a .gz suffix compresses it")
+	compressOutput := flag.Bool("compress-output", false, "Gzip the -o file even without a .gz suffix")
+	flushInterval := flag.Duration("flush-interval", 0, "How often the -o file is flushed to disk instead of after every result, e.g. 5s for a large .gz (0 = every result)")
+	appendOutput := flag.Bool("append", false, "Append to the -o file instead of truncating it")
+	teeOutput := flag.Bool("tee", true, "With -o, still print results on stdout; -tee=false writes only the file")
+	recheckAfter := flag.Duration("recheck-after", 0, "With -checkpoint, recheck proxies whose last check is older than this, e.g. 6h, and skip the rest (0 = skip all checked)")
+	method := flag.String("method", http.MethodGet, "HTTP method of the check request, e.g. POST")
+	data := flag.String("data", "", "Request body sent with every check; Content-Type defaults to application/x-www-form-urlencoded")
+	dataFile := flag.String("data-file", "", "Read the request body from FILE instead of -data")
+	headFirst := flag.Bool("head-first", false, "When only the status is checked (-s or -require-status, no regex), send HEAD instead of GET, falling back to GET on 405/501")
+	roundRobinPath := flag.String("round-robin-targets", "", "File of target URLs handed to proxies in strict rotation, one target per proxy")
+	selfTest := flag.Bool("self-test", false, "Check this build end to end against in-process stub proxies and a target server, then exit (nonzero on failure)")
+	canary := flag.String("canary", "", "Proxy that must fail the checks; the run aborts if it passes, since the success criteria are too loose")
+	netnsName := flag.String("netns", "", "Linux only: open all connections inside this network namespace (a name under /var/run/netns or a path)")
+	webhookURL := flag.String("webhook", "", "POST each valid proxy as JSON to this URL, retrying failed deliveries")
+	webhookWorkers := flag.Int("webhook-workers", 4, "Concurrent -webhook deliveries")
+	detectSW := flag.Bool("detect-software", false, "Guess the software (squid, tinyproxy, ...) of valid HTTP proxies from their Via, Server and X-Cache headers")
+	replayPath := flag.String("replay", "", "Re-check the proxies of a previous -json results file, each against the target it recorded")
+	costDispatch := flag.Bool("cost-dispatch", false, "Dispatch proxies by scheme, giving each scheme an equal share of worker time based on its recent check cost")
+	verifyHash := flag.Bool("verify-hash", false, "Fail proxies whose response body differs (SHA-256) from a direct fetch of -u")
+	ignoreRegex := flag.String("ignore-regex", "", "With -verify-hash, remove matches of this regex from bodies before hashing")
+	flagModified := flag.Bool("flag-modified", false, "With -verify-hash, keep proxies whose body differs and mark them modified instead of failing them")
+	budget := flag.Duration("per-proxy-budget", 0, "Total time allowed for one proxy across -n checks, retries, samples and fallbacks, e.g. 10s (0 = no limit)")
+	dialRetriesFlag := flag.Int("dial-retries", 0, "Retry only the TCP connect to the proxy up to N times (max 5), after a short pause")
+	proxyAuth := flag.String("proxy-auth", "", "Credentials user:pass used for every proxy that has none of its own (Proxy-Authorization for HTTP, username/password for SOCKS5)")
+	inputFormat := flag.String("input-format", inputAuto, "Proxy list line format: auto (URLs, plus host:port:user:pass lines), url, or host:port:user:pass")
+	normalizeCreds := flag.Bool("normalize-credentials", false, "URL-encode proxy credentials so special characters (@ : /) in passwords work")
+	sampleRate := flag.Float64("sample-rate", 1.0, "Check each proxy with this probability (0 < rate <= 1, e.g. 0.1)")
+	seed := flag.Uint64("seed", 0, "Seed for randomized behavior, for reproducible runs (0 = random)")
+	var fallbacks headerFlags
+	flag.Var(&fallbacks, "fallback-url", "Alternate target tried in order when -u answers with a bad status or no match (repeatable)")
 	var headers headerFlags
 	flag.Var(&headers, "H", "Custom request header (can be used multiple times, e.g. -H \"User-Agent: custom\")")
+	userAgent := flag.String("user-agent", "", "User-Agent sent with every request")
+	userAgentFile := flag.String("user-agent-file", "", "File of User-Agent strings, one per line; each request picks one at random")
+	flag.Var(&headers, "header", "Same as -H, e.g. -header \"Accept-Language: en-US,en;q=0.9\"")
 	flag.Parse()
 
+	if *selfTest {
+		os.Exit(runSelfTest())
+	}
+
+	// -quiet overrides every flag that would write diagnostics to stderr
+	if *quiet {
+		*verbose, *showProgress, *forceProgress, *printStats, *tuiMode = false, false, false, false, false
+	}
+
+	// infof prints progress lines that -quiet-errors-only suppresses, and
+	// warnf warnings, which only -quiet does; errors are always printed
+	infof := func(format string, args ...any) {
+		if !*quietErrors && !*quiet {
+			fmt.Fprintf(os.Stderr, format, args...)
+		}
+	}
+	warnf := func(format string, args ...any) {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, format, args...)
+		}
+	}
+
+	var urlTmpl *template.Template
+	if *urlTemplate != "" {
+		if *target != "" || *tcpMode {
+			fmt.Fprintln(os.Stderr, "Error: -url-template cannot be combined with -u or -tcp")
+			os.Exit(1)
+		}
+		var err error
+		if urlTmpl, err = parseURLTemplate(*urlTemplate); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid url template:", err)
+			os.Exit(1)
+		}
+		*target = *urlTemplate
+	}
+
+	var suite []suiteRow
+	if *suitePath != "" {
+		if *target != "" || *tcpMode || *regexStr != "" || *requireRegex != "" {
+			fmt.Fprintln(os.Stderr, "Error: -suite cannot be combined with -u, -url-template, -tcp, -r or -require-regex")
+			os.Exit(1)
+		}
+		var err error
+		if suite, err = loadSuite(*suitePath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: suite:", err)
+			os.Exit(1)
+		}
+		*target = suite[0].url
+	}
+
+	var urls []string
+	if *urlsPath != "" {
+		if *target != "" || *tcpMode {
+			fmt.Fprintln(os.Stderr, "Error: -urls cannot be combined with -u, -url-template, -suite or -tcp")
+			os.Exit(1)
+		}
+		var err error
+		if urls, err = loadTargets(*urlsPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: urls:", err)
+			os.Exit(1)
+		}
+		*target = urls[0]
+	}
+	if *matchPolicy != matchAll && *matchPolicy != matchAny {
+		fmt.Fprintln(os.Stderr, "Error: -match-policy must be all or any")
+		os.Exit(1)
+	}
+
+	var rrTargets []string
+	if *roundRobinPath != "" {
+		if *target != "" || *tcpMode || *replayPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -round-robin-targets cannot be combined with -u, -url-template, -suite, -tcp or -replay")
+			os.Exit(1)
+		}
+		var err error
+		if rrTargets, err = loadTargets(*roundRobinPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: round-robin-targets:", err)
+			os.Exit(1)
+		}
+		*target = rrTargets[0]
+	}
+
 	if *target == "" && !*tcpMode {
 		*target = "SMART_MODE"
 	}
@@ -480,6 +1258,10 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Error: timeout must be greater than 0")
 		os.Exit(1)
 	}
+	if *connectTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -connect-timeout must not be negative")
+		os.Exit(1)
+	}
 	if *threads <= 0 {
 		fmt.Fprintln(os.Stderr, "Error: threads must be greater than 0")
 		os.Exit(1)
@@ -496,16 +1278,386 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Error: expected status must be >= 0")
 		os.Exit(1)
 	}
+	if *expectedStatus > 0 {
+		requireStatus = append(requireStatus, *expectedStatus)
+	}
+	if *requireRegex != "" {
+		if *regexStr != "" {
+			fmt.Fprintln(os.Stderr, "Error: use either -r or -require-regex, not both")
+			os.Exit(1)
+		}
+		*regexStr = *requireRegex
+	}
+	if *requireMaxLatency < 0 {
+		fmt.Fprintln(os.Stderr, "Error: require-max-latency must be >= 0")
+		os.Exit(1)
+	}
+	if *requireMaxDial < 0 {
+		fmt.Fprintln(os.Stderr, "Error: require-max-dial must be >= 0")
+		os.Exit(1)
+	}
+	var userAgents []string
+	switch {
+	case *userAgent != "" && *userAgentFile != "":
+		fmt.Fprintln(os.Stderr, "Error: use either -user-agent or -user-agent-file, not both")
+		os.Exit(1)
+	case *userAgent != "":
+		userAgents = []string{*userAgent}
+	case *userAgentFile != "":
+		lines, err := readProxiesFromFile(*userAgentFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: user-agent-file:", err)
+			os.Exit(1)
+		}
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "#") {
+				userAgents = append(userAgents, l)
+			}
+		}
+		if len(userAgents) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: user-agent-file: no user agents in", *userAgentFile)
+			os.Exit(1)
+		}
+	}
+	if *verifyTLS && *insecure {
+		fmt.Fprintln(os.Stderr, "Error: -verify-tls and -k contradict each other")
+		os.Exit(1)
+	}
+	for _, h := range headers {
+		if err := checkHeader(h); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	if *maxHeaderBytes <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: max header bytes must be greater than 0")
+		os.Exit(1)
+	}
+	switch *outputFormat {
+	case "text":
+	case "json":
+		*jsonOutput = true
+	case "csv":
+		if *jsonOutput {
+			fmt.Fprintln(os.Stderr, "Error: -output csv cannot be combined with -json")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Error: -output must be text, json or csv")
+		os.Exit(1)
+	}
+	if *emitSchema && !*jsonOutput {
+		fmt.Fprintln(os.Stderr, "Error: -emit-schema requires -json")
+		os.Exit(1)
+	}
+	if (*kafkaBrokers == "") != (*kafkaTopic == "") {
+		fmt.Fprintln(os.Stderr, "Error: -kafka and -kafka-topic must be used together")
+		os.Exit(1)
+	}
+	if *checkpointInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: checkpoint interval must be greater than 0")
+		os.Exit(1)
+	}
+	if *keepFastestPct < 0 || *keepFastestPct > 100 {
+		fmt.Fprintln(os.Stderr, "Error: keep-fastest-pct must be between 0 and 100")
+		os.Exit(1)
+	}
+	if *sortBy != "" && *sortBy != "latency" {
+		fmt.Fprintln(os.Stderr, "Error: -sort must be latency")
+		os.Exit(1)
+	}
+
+	if len(fallbacks) > 0 && (*tcpMode || *target == "SMART_MODE" || *suitePath != "") {
+		fmt.Fprintln(os.Stderr, "Error: -fallback-url requires a -u or -url-template target")
+		os.Exit(1)
+	}
+	for _, fb := range fallbacks {
+		if !strings.HasPrefix(fb, "http://") && !strings.HasPrefix(fb, "https://") && !isLocalTarget(fb) {
+			fmt.Fprintln(os.Stderr, "Error: -fallback-url must start with http://, https://, file:// or data:")
+			os.Exit(1)
+		}
+	}
+
+	if *maxRedirects < 0 {
+		fmt.Fprintln(os.Stderr, "Error: max-redirects must be >= 0")
+		os.Exit(1)
+	}
+	if *redirectPass && *noFollow {
+		fmt.Fprintln(os.Stderr, "Error: -redirect-as-success cannot be combined with -no-follow")
+		os.Exit(1)
+	}
+	if *samples < 1 {
+		fmt.Fprintln(os.Stderr, "Error: samples must be greater than 0")
+		os.Exit(1)
+	}
+	if *samples > 1 && *tcpMode {
+		fmt.Fprintln(os.Stderr, "Error: -samples is not supported in -tcp mode")
+		os.Exit(1)
+	}
+	if *speedTest && (*target == "SMART_MODE" || *tcpMode || *headFirst) {
+		fmt.Fprintln(os.Stderr, "Error: -speed-test needs a -u target and cannot be combined with -tcp or -head-first")
+		os.Exit(1)
+	}
+	if *minSpeed < 0 || (*minSpeed > 0 && !*speedTest) {
+		fmt.Fprintln(os.Stderr, "Error: -min-speed must be >= 0 and requires -speed-test")
+		os.Exit(1)
+	}
+	if *maxJitter < 0 || (*maxJitter > 0 && *samples < 2) {
+		fmt.Fprintln(os.Stderr, "Error: -max-jitter must be >= 0 and needs -samples of at least 2")
+		os.Exit(1)
+	}
+
+	ports, err := parsePorts(*scanPorts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: scan-ports:", err)
+		os.Exit(1)
+	}
+
+	rotateBytes, err := parseByteSize(*rotateSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: rotate-size:", err)
+		os.Exit(1)
+	}
+	if *rotateInterval < 0 {
+		fmt.Fprintln(os.Stderr, "Error: rotate-interval must be >= 0")
+		os.Exit(1)
+	}
+
+	if len(countries) > 0 && *geoipDB == "" {
+		fmt.Fprintln(os.Stderr, "Error: -country requires -geoip-db")
+		os.Exit(1)
+	}
+	if len(excludeASN) > 0 && *asnDB == "" {
+		fmt.Fprintln(os.Stderr, "Error: -exclude-asn requires -asn-db")
+		os.Exit(1)
+	}
+
+	switch *inputFormat {
+	case inputAuto, inputURL, inputHostPortAuth:
+	default:
+		fmt.Fprintln(os.Stderr, "Error: -input-format must be auto, url or host:port:user:pass")
+		os.Exit(1)
+	}
+
+	defaultScheme = strings.ToLower(strings.TrimSpace(*defaultSchemeFlag))
+	if !slices.Contains(probeableSchemes, defaultScheme) {
+		fmt.Fprintf(os.Stderr, "Error: unsupported -default-scheme %q; use http, https, socks4, socks4a or socks5\n", *defaultSchemeFlag)
+		os.Exit(1)
+	}
+
+	if *via != "" {
+		u, err := url.Parse(*via)
+		if err != nil || u.Port() == "" || !slices.Contains([]string{"http", "socks4", "socks4a", "socks5"}, u.Scheme) {
+			fmt.Fprintln(os.Stderr, "Error: -via must be http://, socks4://, socks4a:// or socks5://host:port (an https:// upstream is not supported)")
+			os.Exit(1)
+		}
+		viaUpstream = *via
+	}
+
+	var chainNext *url.URL
+	if *chainSocks != "" {
+		if *tcpMode {
+			fmt.Fprintln(os.Stderr, "Error: -chain-socks is not supported in -tcp mode")
+			os.Exit(1)
+		}
+		u, err := url.Parse(*chainSocks)
+		if err != nil || u.Scheme != "socks5" || u.Port() == "" {
+			fmt.Fprintln(os.Stderr, "Error: -chain-socks must be socks5://host:port")
+			os.Exit(1)
+		}
+		chainNext = u
+	}
+
+	readLimit, err := parseByteSize(*readLimitFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: read-limit:", err)
+		os.Exit(1)
+	}
+	if readLimit == 0 || readLimit > maxReadLimit {
+		if readLimit > maxReadLimit {
+			warnf("Warning: read-limit capped at 16MB\n")
+		}
+		readLimit = maxReadLimit
+	}
+
+	maxMemBytes, err := parseByteSize(*maxMemory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: max-memory:", err)
+		os.Exit(1)
+	}
+
+	if *idleProbe < 0 {
+		fmt.Fprintln(os.Stderr, "Error: probe-keepalive-idle must be >= 0")
+		os.Exit(1)
+	}
+
+	if *compressOutput && *outputPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -compress-output requires -o")
+		os.Exit(1)
+	}
+	if *flushInterval < 0 {
+		fmt.Fprintln(os.Stderr, "Error: flush interval must not be negative")
+		os.Exit(1)
+	}
+	if *appendOutput && *outputPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -append requires -o")
+		os.Exit(1)
+	}
+
+	if *recheckAfter < 0 || (*recheckAfter > 0 && *checkpointPath == "") {
+		fmt.Fprintln(os.Stderr, "Error: -recheck-after must be >= 0 and needs -checkpoint")
+		os.Exit(1)
+	}
+
+	if *webhookURL != "" && !strings.HasPrefix(*webhookURL, "http://") && !strings.HasPrefix(*webhookURL, "https://") {
+		fmt.Fprintln(os.Stderr, "Error: -webhook must be an http:// or https:// URL")
+		os.Exit(1)
+	}
+	if *webhookWorkers <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: webhook workers must be greater than 0")
+		os.Exit(1)
+	}
+
+	if *budget < 0 {
+		fmt.Fprintln(os.Stderr, "Error: per-proxy-budget must be >= 0")
+		os.Exit(1)
+	}
+
+	if *byCountryDir != "" && *geoipDB == "" {
+		fmt.Fprintln(os.Stderr, "Error: -o-by-country requires -geoip-db")
+		os.Exit(1)
+	}
+
+	if *dialRetriesFlag < 0 || *dialRetriesFlag > 5 {
+		fmt.Fprintln(os.Stderr, "Error: dial-retries must be between 0 and 5")
+		os.Exit(1)
+	}
+	dialRetries = *dialRetriesFlag
+
+	if *resetRetries < 0 || *resetRetries > 5 {
+		fmt.Fprintln(os.Stderr, "Error: reconnect-on-reset must be between 0 and 5")
+		os.Exit(1)
+	}
+	if *resetBackoff != "constant" && *resetBackoff != "exponential" && *resetBackoff != "jittered" {
+		fmt.Fprintln(os.Stderr, "Error: reset-backoff must be constant, exponential or jittered")
+		os.Exit(1)
+	}
+	if *resetDelay < 0 {
+		fmt.Fprintln(os.Stderr, "Error: reset-delay must be >= 0")
+		os.Exit(1)
+	}
+	if *rps < 0 {
+		fmt.Fprintln(os.Stderr, "Error: rps must be >= 0")
+		os.Exit(1)
+	}
+	if *jitter < 0 {
+		fmt.Fprintln(os.Stderr, "Error: jitter must be >= 0")
+		os.Exit(1)
+	}
+	if *retries < 0 || *retries > 10 {
+		fmt.Fprintln(os.Stderr, "Error: retries must be between 0 and 10")
+		os.Exit(1)
+	}
+	if *retryBackoff != "linear" && *retryBackoff != "exponential" {
+		fmt.Fprintln(os.Stderr, "Error: retry-backoff must be linear or exponential")
+		os.Exit(1)
+	}
+	if *retryDelay < 0 {
+		fmt.Fprintln(os.Stderr, "Error: retry-delay must be >= 0")
+		os.Exit(1)
+	}
+	if *connProbe < 0 {
+		fmt.Fprintln(os.Stderr, "Error: conn-probe must be >= 0")
+		os.Exit(1)
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		fmt.Fprintln(os.Stderr, "Error: sample rate must be in (0, 1]")
+		os.Exit(1)
+	}
 	if *tcpMode {
 		// TCP mode: validate target format (host:port)
 		if !strings.Contains(*target, ":") {
 			fmt.Fprintln(os.Stderr, "Error: TCP mode requires target in host:port format")
 			os.Exit(1)
 		}
-	} else if *target != "SMART_MODE" {
+	} else if *target != "SMART_MODE" && urlTmpl == nil {
 		// HTTP mode: validate URL format
-		if !strings.HasPrefix(*target, "http://") && !strings.HasPrefix(*target, "https://") {
-			fmt.Fprintln(os.Stderr, "Error: HTTP mode requires target URL starting with http:// or https://")
+		if !strings.HasPrefix(*target, "http://") && !strings.HasPrefix(*target, "https://") && !isLocalTarget(*target) {
+			fmt.Fprintln(os.Stderr, "Error: HTTP mode requires target URL starting with http://, https://, file:// or data:")
+			os.Exit(1)
+		}
+	}
+	if *normalizeLatency && (*tcpMode || *urlTemplate != "" || isLocalTarget(*target)) {
+		fmt.Fprintln(os.Stderr, "Error: -normalize-latency needs a fixed http(s) target")
+		os.Exit(1)
+	}
+	if *http2 && *alpnList != "" {
+		fmt.Fprintln(os.Stderr, "Error: -http2 cannot be combined with -alpn, which sets the offered protocols itself")
+		os.Exit(1)
+	}
+	var alpn []string
+	if *alpnList != "" {
+		if !strings.HasPrefix(*target, "https://") {
+			fmt.Fprintln(os.Stderr, "Error: -alpn requires an https:// target")
+			os.Exit(1)
+		}
+		var err error
+		if alpn, err = parseALPN(*alpnList); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: alpn:", err)
+			os.Exit(1)
+		}
+	}
+	if *detectSSLStrip && !strings.HasPrefix(*target, "https://") {
+		fmt.Fprintln(os.Stderr, "Error: -detect-ssl-strip requires an https:// target")
+		os.Exit(1)
+	}
+
+	*method = strings.ToUpper(*method)
+	if !httpMethodRe.MatchString(*method) {
+		fmt.Fprintln(os.Stderr, "Error: invalid -method", *method)
+		os.Exit(1)
+	}
+	var body []byte
+	switch {
+	case *data != "" && *dataFile != "":
+		fmt.Fprintln(os.Stderr, "Error: use either -data or -data-file, not both")
+		os.Exit(1)
+	case *data != "":
+		body = []byte(*data)
+	case *dataFile != "":
+		var err error
+		if body, err = os.ReadFile(*dataFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: data-file:", err)
+			os.Exit(1)
+		}
+	}
+	if (*method != http.MethodGet || body != nil) && (*target == "SMART_MODE" || *tcpMode || *headFirst) {
+		fmt.Fprintln(os.Stderr, "Error: -method, -data and -data-file need an HTTP -u target and cannot be combined with -head-first")
+		os.Exit(1)
+	}
+
+	if *headFirst && (len(requireStatus) == 0 || *regexStr != "" || *target == "SMART_MODE" || *tcpMode || *suitePath != "" || *drainBody) {
+		fmt.Fprintln(os.Stderr, "Error: -head-first needs a status-only check: -u with -s or -require-status, and no -r, -suite or -drain-body")
+		os.Exit(1)
+	}
+
+	if *verifyHash && (*target == "SMART_MODE" || *tcpMode || *urlTemplate != "" || *suitePath != "" || *roundRobinPath != "" || *replayPath != "" || *headFirst) {
+		fmt.Fprintln(os.Stderr, "Error: -verify-hash needs one fixed -u target and cannot be combined with -head-first")
+		os.Exit(1)
+	}
+	if *flagModified && !*verifyHash {
+		fmt.Fprintln(os.Stderr, "Error: -flag-modified requires -verify-hash")
+		os.Exit(1)
+	}
+	var ignoreRe *regexp.Regexp
+	if *ignoreRegex != "" {
+		if !*verifyHash {
+			fmt.Fprintln(os.Stderr, "Error: -ignore-regex requires -verify-hash")
+			os.Exit(1)
+		}
+		var err error
+		if ignoreRe, err = regexp.Compile(*ignoreRegex); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid ignore regex:", err)
 			os.Exit(1)
 		}
 	}
@@ -525,66 +1677,171 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Error: invalid regex:", err)
 		os.Exit(1)
 	}
+	if urls != nil {
+		suite = urlRows(urls, re)
+	}
+	var excludeRe *regexp.Regexp
+	if *excludeRegex != "" {
+		if excludeRe, err = regexp.Compile(*excludeRegex); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: invalid exclude regex:", err)
+			os.Exit(1)
+		}
+	}
 
-	proxies, err := readProxiesFromStdin()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error reading proxies from stdin:", err)
-		os.Exit(1)
+	if *netnsName != "" {
+		dial, err := newNetnsDial(*netnsName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: netns:", err)
+			os.Exit(1)
+		}
+		netnsDial = dial
 	}
 
-	if len(proxies) == 0 && *listFile != "" {
-		proxies, err = readProxiesFromFile(*listFile)
+	// Piped proxies are streamed to the workers as they arrive, unless a step
+	// needs the whole list first; -l and -replay files are read up front
+	streaming := *replayPath == "" && *listFile == "" && len(listURLs) == 0 && stdinIsPipe() && !*portScan && !*costDispatch && *roundRobinPath == ""
+	var proxies []string
+	var replayTargets map[string]string
+	switch {
+	case streaming:
+	case *replayPath != "":
+		proxies, replayTargets, err = loadReplay(*replayPath)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error reading proxies from file:", err)
+			fmt.Fprintln(os.Stderr, "Error reading replay file:", err)
+			os.Exit(1)
+		}
+	default:
+		// stdin comes first, as it always has; -l and -list-url are the fallback
+		if proxies, err = readProxiesFromStdin(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading proxies from stdin:", err)
 			os.Exit(1)
 		}
+		if len(proxies) > 0 {
+			break
+		}
+		if *listFile != "" {
+			proxies, err = readProxiesFromFile(*listFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading proxies from file:", err)
+				os.Exit(1)
+			}
+		}
+		for _, u := range listURLs {
+			list, err := fetchProxyList(u)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error fetching proxy list %s: %v\n", u, err)
+				os.Exit(1)
+			}
+			proxies = append(proxies, list...)
+		}
+		proxies = proxyra.UniqProxies(proxies)
 	}
 
-	if len(proxies) == 0 {
+	if !streaming && len(proxies) == 0 {
 		fmt.Fprintln(os.Stderr, "Error: no proxies provided")
 		os.Exit(1)
 	}
 
-	proxies = uniqProxies(proxies)
+	if *portScan {
+		total := len(proxies)
+		proxies = expandPortScan(proxyra.UniqProxies(proxies), ports, *timeout, *threads)
+		infof("Port scan expanded %d lines into %d proxies\n", total, len(proxies))
+		if len(proxies) == 0 {
+			os.Exit(0)
+		}
+	}
+
+	var defaultAuth *url.Userinfo
+	if *proxyAuth != "" {
+		user, pass, ok := strings.Cut(*proxyAuth, ":")
+		if !ok || user == "" {
+			fmt.Fprintln(os.Stderr, "Error: -proxy-auth must be user:pass")
+			os.Exit(1)
+		}
+		defaultAuth = url.UserPassword(user, pass)
+	}
+
+	rng := rand.New(&lockedSource{src: rand.NewPCG(*seed, *seed)})
+	if *seed == 0 {
+		rng = rand.New(&lockedSource{src: rand.NewPCG(rand.Uint64(), rand.Uint64())})
+	}
+
+	var resets, transient proxyra.RetryPolicy
+	if *resetRetries > 0 {
+		resets = backoffPolicy(*resetBackoff, *resetDelay, *resetRetries, rng)
+	}
+	if *retries > 0 {
+		transient = backoffPolicy(*retryBackoff, *retryDelay, *retries, rng)
+	}
+
+	var cp *checkpoint
+	if *checkpointPath != "" {
+		var err error
+		if cp, err = loadCheckpoint(*checkpointPath, warnf); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: checkpoint:", err)
+			os.Exit(1)
+		}
+	}
+
+	filter := &proxyFilter{
+		format:       *inputFormat,
+		warnf:        warnf,
+		auth:         defaultAuth,
+		noXray:       viaUpstream != "",
+		normalize:    *normalizeCreds,
+		noPrivate:    *noPrivate,
+		sampleRate:   *sampleRate,
+		rng:          sampleRand(*seed),
+		cp:           cp,
+		recheckAfter: *recheckAfter,
+		seen:         make(map[string]struct{}),
+	}
 
-	// Convert xray links (vless://, vmess://, etc.) to local SOCKS5 proxies via xray
 	var xrayMgr *xray.Manager
-	proxyMap := make(map[string]string) // localSocks5Addr -> originalXrayLink
-	for i, p := range proxies {
-		if isXrayLink(p) {
-			if xrayMgr == nil {
-				xrayMgr = xray.NewManager()
-			}
-			ob, err := xray.ParseLink(p)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error parsing xray link: %v\n", err)
-				continue
+	var proxyMap sync.Map // localSocks5Addr -> originalXrayLink
+	if !streaming {
+		kept := proxies[:0]
+		for _, p := range proxies {
+			if p, ok := filter.admit(p); ok {
+				kept = append(kept, p)
 			}
-			inst, err := xrayMgr.AddOutbound(ob)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error adding xray outbound: %v\n", err)
-				continue
+		}
+		proxies = kept
+		filter.report(infof)
+		if len(proxies) == 0 {
+			if filter.private > 0 && filter.sampledOut == 0 && filter.resumed == 0 {
+				fmt.Fprintln(os.Stderr, "Error: no proxies left after -no-private")
+				os.Exit(1)
 			}
-			localAddr := fmt.Sprintf("socks5://127.0.0.1:%d", inst.Port)
-			proxyMap[localAddr] = p
-			proxies[i] = localAddr
+			os.Exit(0)
 		}
-	}
-	if xrayMgr != nil {
-		if err := xrayMgr.Start(); err != nil {
+
+		// Convert xray links (vless://, vmess://, etc.) to local SOCKS5 proxies via xray
+		if xrayMgr, err = startXray(proxies, &proxyMap); err != nil {
 			fmt.Fprintf(os.Stderr, "Error starting xray: %v\n", err)
 			os.Exit(1)
 		}
-		defer xrayMgr.StopAll()
 	}
+	defer func() {
+		// a streamed run starts xray in the feeder, which is done by now
+		if xrayMgr != nil {
+			xrayMgr.StopAll()
+		}
+	}()
 
 	// Use smaller buffer to avoid excessive memory with large proxy lists
 	bufferSize := 100
-	if len(proxies) < bufferSize {
+	if !streaming && len(proxies) < bufferSize {
 		bufferSize = len(proxies)
 	}
 	jobs := make(chan string, bufferSize)
-	out := make(chan string, bufferSize)
+	var sched *costScheduler
+	if *costDispatch {
+		sched = newCostScheduler(proxies, *timeout)
+		// hand out each job only when a worker is free, so the choice uses fresh costs
+		jobs = make(chan string)
+	}
+	out := make(chan Result, bufferSize)
 
 	var maxFoundPtr *int
 	var maxMutex sync.Mutex
@@ -595,23 +1852,512 @@ func main() {
 		maxFoundPtr = &maxFoundCopy
 	}
 
+	// The first interrupt stops new checks and cancels the running ones, and
+	// the run then ends normally so everything found so far is written out
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+
+	opts := &checkOptions{
+		target:         *target,
+		timeout:        *timeout,
+		connectTimeout: *connectTimeout,
+		re:             re,
+		suite:          suite,
+		fallbacks:      fallbacks,
+		samples:        *samples,
+		maxJitter:      *maxJitter,
+		strictScheme:   *strictScheme,
+		excludeASN:     excludeASN,
+		countries:      countries,
+		chainSocks:     chainNext,
+		errorsOnly:     *quietErrors || *quiet,
+		quiet:          *quiet,
+		headFirst:      *headFirst,
+		method:         *method,
+		body:           body,
+		detectSoftware: *detectSW,
+		ignoreRe:       ignoreRe,
+		keepModified:   *flagModified,
+		budget:         *budget,
+		insecure:       *insecure,
+		checkCount:     *checkCount,
+		tcpMode:        *tcpMode,
+		policy:         successPolicy{statuses: requireStatus, maxLatency: *requireMaxLatency, maxDial: *requireMaxDial, exclude: excludeRe, headers: headerRegexes},
+		headers:        headers,
+		userAgents:     userAgents,
+		probeLocation:  *probeLocation,
+		noFollow:       *noFollow,
+		maxRedirects:   *maxRedirects,
+		redirectPass:   *redirectPass,
+		maxHeaderBytes: *maxHeaderBytes,
+		readLimit:      readLimit,
+		detectSSLStrip: *detectSSLStrip,
+		alpn:           alpn,
+		http2:          *http2,
+		retry:          requestPolicy(resets, transient),
+		urlTemplate:    urlTmpl,
+		rng:            rng,
+		seed:           *seed,
+		drainBody:      *drainBody,
+		speedTest:      *speedTest,
+		minSpeed:       *minSpeed,
+		stats:          &runStats{},
+		ipEchoURL:      *ipEchoURL,
+		needExitIP:     *warnDupExit || *dedupeExitScheme,
+		stderrMutex:    &stderrMutex,
+		ctx:            runCtx,
+		jitter:         *jitter,
+	}
+	if *rps > 0 {
+		// a burst of one keeps the pace even from the very first requests
+		opts.limiter = rate.NewLimiter(rate.Limit(*rps), 1)
+	}
+
+	if rrTargets != nil {
+		opts.assigned = assignTargets(proxies, rrTargets)
+	}
+	if len(replayTargets) > 0 {
+		opts.assigned = make(map[string]string, len(replayTargets))
+		for _, p := range proxies {
+			orig := p
+			if o, found := proxyMap.Load(p); found {
+				orig = o.(string)
+			}
+			if t, ok := replayTargets[orig]; ok {
+				opts.assigned[p] = t
+			}
+		}
+	}
+
+	if *connProbe > 0 {
+		if tunnelTarget(opts) == "" {
+			fmt.Fprintln(os.Stderr, "Error: -conn-probe needs a fixed network target")
+			os.Exit(1)
+		}
+		opts.connProbeMax = *connProbe
+	}
+
+	if *idleProbe > 0 {
+		if tunnelTarget(opts) == "" {
+			fmt.Fprintln(os.Stderr, "Error: -probe-keepalive-idle needs a fixed network target")
+			os.Exit(1)
+		}
+		opts.idleProbeMax = *idleProbe
+	}
+
+	if *dnsOverProxy {
+		if *dnsProxyHost == "" || *dnsLocalHost == "" {
+			fmt.Fprintln(os.Stderr, "Error: -dns-over-proxy requires -dns-proxy-host and -dns-local-host")
+			os.Exit(1)
+		}
+		opts.dnsProxyHost, opts.dnsLocalHost = withDefaultPort(*dnsProxyHost), withDefaultPort(*dnsLocalHost)
+		if err := validateDNSHosts(opts.dnsProxyHost, opts.dnsLocalHost); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *geoipDB != "" {
+		geo, err := openMMDB[countryRecord](*geoipDB)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: geoip-db:", err)
+			os.Exit(1)
+		}
+		defer geo.Close()
+		opts.geo = geo
+	}
+
+	if *asnDB != "" {
+		asn, err := openMMDB[asnRecord](*asnDB)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: asn-db:", err)
+			os.Exit(1)
+		}
+		defer asn.Close()
+		opts.asn = asn
+		opts.needExitIP = true
+	}
+
+	if urls != nil {
+		opts.matchPolicy = *matchPolicy
+	}
+
+	if *autoScheme {
+		if *strictScheme || *roundRobinPath != "" || *replayPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -auto-scheme cannot be combined with -strict-scheme, -round-robin-targets or -replay")
+			os.Exit(1)
+		}
+		var err error
+		if opts.autoSchemes, err = parseSchemeOrder(*autoSchemeOrder); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: auto-scheme-order:", err)
+			os.Exit(1)
+		}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "default-scheme" {
+				opts.autoSchemes = schemeFirst(opts.autoSchemes, defaultScheme)
+			}
+		})
+	}
+
+	if *traceSample < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -trace-sample must not be negative")
+		os.Exit(1)
+	}
+	if *traceFile != "" && *traceSample == 0 {
+		fmt.Fprintln(os.Stderr, "Error: -trace-file requires -trace-sample")
+		os.Exit(1)
+	}
+	if *traceSample > 0 {
+		if *tcpMode {
+			fmt.Fprintln(os.Stderr, "Error: -trace-sample cannot be used with -tcp")
+			os.Exit(1)
+		}
+		var w io.Writer = os.Stderr
+		mu := opts.stderrMutex
+		if *traceFile != "" {
+			f, err := os.Create(*traceFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: trace-file:", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w, mu = f, &sync.Mutex{}
+		}
+		opts.tracer = newWireTracer(*traceSample, w, mu)
+	}
+
+	if *maxPerExit < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-concurrent-per-exit must not be negative")
+		os.Exit(1)
+	}
+	if *maxPerExit > 0 {
+		opts.exitLimit = newExitLimiter(*maxPerExit)
+		opts.needExitIP = true
+	}
+	if *perHostLimit < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -per-host-limit must not be negative")
+		os.Exit(1)
+	}
+	if *perHostLimit > 0 {
+		opts.hostLimit = newHostLimiter(*perHostLimit)
+	}
+
+	// outputs are opened before any request goes out, so a bad path fails
+	// the run up front; rotated files join stdout once the dashboard has
+	// decided where stdout goes
+	var stdout io.Writer = os.Stdout
+	var outFile *outputFile
+	var rotated *rotatingFile
+	var countryOut *countryFiles
+	var protoOut *protoFile
+	// closeOutputs finishes the output files, once, so that no exit leaves
+	// a compressed one truncated
+	var closeOnce sync.Once
+	closeOutputs := func() {
+		closeOnce.Do(func() {
+			if outFile != nil {
+				if err := outFile.Close(); err != nil {
+					warnf("Warning: output file: %v\n", err)
+				}
+			}
+			if rotated != nil {
+				if err := rotated.Close(); err != nil {
+					warnf("Warning: o-rotate: %v\n", err)
+				}
+			}
+			if countryOut != nil {
+				if err := countryOut.Close(); err != nil {
+					warnf("Warning: o-by-country: %v\n", err)
+				}
+			}
+			if protoOut != nil {
+				if err := protoOut.Close(); err != nil {
+					warnf("Warning: o-proto: %v\n", err)
+				}
+			}
+		})
+	}
+	// fatal reports an error and exits once outputs may be open
+	fatal := func(a ...any) {
+		fmt.Fprintln(os.Stderr, a...)
+		closeOutputs()
+		os.Exit(1)
+	}
+	if *outputPath != "" {
+		var err error
+		if outFile, err = openOutputFile(*outputPath, *compressOutput || strings.HasSuffix(*outputPath, ".gz"), *appendOutput, *flushInterval); err != nil {
+			fatal("Error: output file:", err)
+		}
+		stdout = outFile
+		if *teeOutput {
+			stdout = io.MultiWriter(os.Stdout, outFile)
+		}
+	}
+	if *rotatePath != "" {
+		var err error
+		if rotated, err = openRotatingFile(*rotatePath, rotateBytes, *rotateInterval); err != nil {
+			fatal("Error: o-rotate:", err)
+		}
+	}
+	if *byCountryDir != "" {
+		var err error
+		if countryOut, err = newCountryFiles(*byCountryDir); err != nil {
+			fatal("Error: o-by-country:", err)
+		}
+	}
+	if *protoPath != "" {
+		var err error
+		if protoOut, err = createProtoFile(*protoPath); err != nil {
+			fatal("Error: o-proto:", err)
+		}
+	}
+
+	if *dnsLeakTest {
+		answer := net.ParseIP(*dnsLeakAnswer)
+		if *dnsLeakZone == "" || answer == nil || answer.To4() == nil {
+			fatal("Error: -dns-leak-test requires -dns-leak-zone and an IPv4 -dns-leak-answer")
+		}
+		srv, err := startLeakServer(*dnsLeakListen, *dnsLeakZone, answer)
+		if err != nil {
+			fatal("Error: dns leak server:", err)
+		}
+		defer srv.Close()
+		opts.leakServer = srv
+		if opts.localResolvers, err = srv.calibrate(opts); err != nil {
+			fatal("Error: dns leak calibration:", err)
+		}
+	}
+
+	if *normalizeLatency {
+		baselineTarget := *target
+		if baselineTarget == "SMART_MODE" {
+			baselineTarget = "http://icanhazip.com"
+		}
+		opts.baselines = measureBaselines(baselineTarget, opts)
+		for _, scheme := range baselineSchemes {
+			if b, ok := opts.baselines[scheme]; ok {
+				opts.infof("Latency baseline %s: %dms\n", scheme, b)
+			}
+		}
+	}
+
+	if *detectCache {
+		opts.cacheURL = *cacheURL
+	}
+
+	if *judgeURL != "" && !strings.HasPrefix(*judgeURL, "http://") && !strings.HasPrefix(*judgeURL, "https://") {
+		fatal("Error: -judge must start with http:// or https://")
+	}
+	if *judgeURL != "" || *expectIPChange {
+		ip, err := fetchExitIP("", opts)
+		if err != nil {
+			fatal("Error: -judge and -expect-ip-change need this machine's public IP, but -ip-echo-url failed:", err)
+		}
+		opts.judgeURL, opts.realIP = *judgeURL, ip
+		infof("Public IP: %s\n", ip)
+	}
+	if *expectIPChange {
+		opts.expectIPChange = true
+		opts.needExitIP = true
+	}
+
+	if *fingerprintJA3 {
+		opts.ja3URL = *ja3URL
+		baseline, err := fetchJA3("", opts)
+		if err != nil {
+			warnf("Warning: direct JA3 baseline failed, rewrites will not be flagged: %v\n", err)
+		}
+		opts.ja3Baseline = baseline
+	}
+
+	if *verifyHash {
+		_, body, err := fetchBody("", *target, opts)
+		if err != nil {
+			fatal("Error: direct fetch for -verify-hash failed:", err)
+		}
+		opts.bodyHash = bodyDigest(body, ignoreRe)
+	}
+
+	if *canary != "" {
+		var passed bool
+		if opts.tcpMode {
+			passed = checkProxyTCP(*canary, opts.target, opts.timeout)
+		} else {
+			passed = checkProxyHTTP(*canary, opts).OK
+		}
+		if passed {
+			fatal(fmt.Sprintf("Error: canary %s passed the checks; the success criteria (-r, -s, ...) are too loose to trust, aborting", *canary))
+		}
+	}
+
+	if *controlPath != "" {
+		opts.gate = newPauseGate()
+		ln, err := serveControl(*controlPath, opts.gate, opts.stats)
+		if err != nil {
+			fatal("Error: control socket:", err)
+		}
+		defer ln.Close()
+	}
+
+	// Consumers of every finished check, whatever its outcome
+	var resultHooks []func(Result)
+	if *verbose {
+		resultHooks = append(resultHooks, func(res Result) {
+			opts.logf("%s\n", verboseLine(res))
+		})
+	}
+	if sched != nil {
+		resultHooks = append(resultHooks, sched.observe)
+	}
+
+	cpDone := make(chan struct{})
+	stopCP := make(chan struct{})
+	if cp != nil {
+		resultHooks = append(resultHooks, cp.record)
+		go func() {
+			cp.run(*checkpointInterval, stopCP, opts.logf)
+			close(cpDone)
+		}()
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		opts.logf("Interrupted: finishing up; interrupt again to quit now\n")
+		cancelRun()
+		<-sigs
+		closeOutputs()
+		os.Exit(130)
+	}()
+	// While the dashboard owns the terminal, results are held back and printed at the end
+	var held bytes.Buffer
+	var dash *dashboard
+	dashDone := make(chan struct{})
+	stopDash := make(chan struct{})
+	if *tuiMode {
+		if isTerminal(os.Stderr) {
+			dash = newDashboard(&opts.stats.queued)
+			resultHooks = append(resultHooks, dash.update)
+			if (outFile == nil || *teeOutput) && isTerminal(os.Stdout) {
+				stdout = &held
+				if outFile != nil {
+					stdout = io.MultiWriter(&held, outFile)
+				}
+			}
+			go func() {
+				dash.run(os.Stderr, 250*time.Millisecond, stopDash)
+				close(dashDone)
+			}()
+		} else {
+			warnf("Warning: -tui needs a terminal on stderr, using plain output\n")
+		}
+	}
+
+	if len(resultHooks) > 0 {
+		opts.onResult = func(res Result) {
+			if orig, found := proxyMap.Load(res.Proxy); found {
+				res.Proxy = orig.(string)
+			}
+			for _, hook := range resultHooks {
+				hook(res)
+			}
+		}
+	}
+
+	if !streaming {
+		opts.stats.queued.Store(int64(len(proxies)))
+	}
 	var wg sync.WaitGroup
 	workers := *threads
-	if workers > len(proxies) {
+	if !streaming && workers > len(proxies) {
 		workers = len(proxies)
 	}
+	started := time.Now()
 	wg.Add(workers)
 	for i := 0; i < workers; i++ {
-		go worker(jobs, *target, *timeout, re, out, &wg, *insecure, *checkCount, *tcpMode, *expectedStatus, headers, maxFoundPtr, &maxMutex, done, &stderrMutex)
+		go worker(jobs, opts, out, &wg, maxFoundPtr, &maxMutex, done)
 	}
 
 	// Feed jobs to workers
+	queue := func(p string) bool {
+		select {
+		case jobs <- p:
+			return true
+		case <-done:
+		case <-runCtx.Done():
+		}
+		return false
+	}
+	// fed is closed once the feeder is done with filter, which an interrupt
+	// can leave running after the last worker has exited
+	fed := make(chan struct{})
 	go func() {
+		defer close(fed)
 		defer close(jobs)
-		for _, p := range proxies {
+		if streaming {
+			lines := make(chan string)
+			errc := make(chan error, 1)
+			go streamLines(os.Stdin, lines, errc, done, runCtx.Done())
+			var links []string
+			for {
+				var line string
+				var ok bool
+				select {
+				case line, ok = <-lines:
+				case <-done:
+					return
+				case <-runCtx.Done():
+					return
+				}
+				if !ok {
+					break
+				}
+				p, ok := filter.admit(line)
+				if !ok {
+					continue
+				}
+				// xray links share one xray instance, started once input ends
+				if isXrayLink(p) {
+					links = append(links, p)
+					continue
+				}
+				opts.stats.queued.Add(1)
+				if !queue(p) {
+					return
+				}
+			}
 			select {
-			case jobs <- p:
-			case <-done:
+			case err := <-errc:
+				opts.logf("Error reading proxies from stdin: %v\n", err)
+			default:
+			}
+			filter.report(opts.infof)
+			if len(links) > 0 {
+				var err error
+				if xrayMgr, err = startXray(links, &proxyMap); err != nil {
+					opts.logf("Error starting xray: %v\n", err)
+					return
+				}
+				opts.stats.queued.Add(int64(len(links)))
+				for _, p := range links {
+					if !queue(p) {
+						return
+					}
+				}
+			}
+			return
+		}
+		if sched != nil {
+			for p, ok := sched.next(); ok; p, ok = sched.next() {
+				if !queue(p) {
+					return
+				}
+			}
+			return
+		}
+		for _, p := range proxies {
+			if !queue(p) {
 				return
 			}
 		}
@@ -622,11 +2368,162 @@ func main() {
 		close(out)
 	}()
 
-	for ok := range out {
-		if orig, found := proxyMap[ok]; found {
-			_, _ = os.Stdout.WriteString(orig + "\n")
+	if rotated != nil {
+		// every result is a single Write, so lines are never split across files
+		stdout = io.MultiWriter(stdout, rotated)
+	}
+
+	if *jsonOutput && *emitSchema {
+		writeSchema(stdout)
+	}
+	var csvOut *csvResults
+	if *outputFormat == "csv" {
+		csvOut = newCSVResults(stdout)
+	}
+
+	var kafkaOut *kafkaSink
+	if *kafkaBrokers != "" {
+		kafkaOut = newKafkaSink(*kafkaBrokers, *kafkaTopic, opts.logf)
+	}
+
+	var hook *webhookSink
+	if *webhookURL != "" {
+		hook = newWebhookSink(*webhookURL, *webhookWorkers, opts.logf)
+	}
+
+	exitOwners := make(map[string]string)  // exit IP -> first proxy emitted with it
+	exitGroups := make(map[[2]string]bool) // (exit IP, scheme) pairs already emitted
+
+	emit := func(res Result) {
+		// proxies whose exit could not be looked up form no group and are kept
+		if *dedupeExitScheme && res.ExitIP != "" {
+			group := [2]string{res.ExitIP, res.Scheme}
+			if exitGroups[group] {
+				return
+			}
+			exitGroups[group] = true
+		}
+		if *warnDupExit && res.ExitIP != "" {
+			if first, seen := exitOwners[res.ExitIP]; seen {
+				opts.logf("Warning: %s shares exit IP %s with %s\n", res.Proxy, res.ExitIP, first)
+			} else {
+				exitOwners[res.ExitIP] = res.Proxy
+			}
+		}
+		if kafkaOut != nil {
+			kafkaOut.publish(res)
+		}
+		if hook != nil {
+			hook.publish(res)
+		}
+		if countryOut != nil {
+			if err := countryOut.write(res); err != nil {
+				opts.logf("Warning: o-by-country: %v\n", err)
+			}
+		}
+		if protoOut != nil {
+			if err := protoOut.write(res); err != nil {
+				opts.logf("Warning: o-proto: %v\n", err)
+			}
+		}
+		if *jsonOutput {
+			writeJSON(stdout, res)
+		} else if csvOut != nil {
+			csvOut.write(res)
+		} else if *showLatency && *speedTest {
+			fmt.Fprintf(stdout, "%s %d %d\n", res.Proxy, res.LatencyMs, res.SpeedKBps)
+		} else if *showLatency {
+			fmt.Fprintf(stdout, "%s %d\n", res.Proxy, res.LatencyMs)
 		} else {
-			_, _ = os.Stdout.WriteString(ok + "\n")
+			_, _ = io.WriteString(stdout, res.Proxy+"\n")
 		}
 	}
+
+	progressDone := make(chan struct{})
+	stopProgress := make(chan struct{})
+	if (*showProgress || *forceProgress) && dash == nil && (*forceProgress || isTerminal(os.Stderr)) {
+		go func() {
+			runProgress(os.Stderr, &stderrMutex, opts.stats, 500*time.Millisecond, stopProgress)
+			close(progressDone)
+		}()
+	} else {
+		close(progressDone)
+	}
+
+	buffering := *keepFastestPct > 0 || *sortBy != ""
+	buffered := newResultBuffer(maxMemBytes, *normalizeLatency, opts.logf)
+	defer buffered.Close()
+	for res := range out {
+		if orig, found := proxyMap.Load(res.Proxy); found {
+			res.Proxy = orig.(string)
+		}
+		if buffering {
+			if err := buffered.add(res); err != nil {
+				fatal("Error:", err)
+			}
+			continue
+		}
+		emit(res)
+	}
+	close(stopProgress)
+	<-progressDone
+	<-fed
+	if streaming && filter.unique == 0 {
+		fatal("Error: no proxies provided")
+	}
+	if buffering {
+		keep := make([]bool, len(buffered.latencies))
+		for i := range keep {
+			keep[i] = true
+		}
+		if *keepFastestPct > 0 {
+			keep = fastestMask(buffered.latencies, *keepFastestPct)
+		}
+		var err error
+		if *sortBy == "latency" {
+			var order []int
+			for _, i := range latencyOrder(buffered.latencies) {
+				if keep[i] {
+					order = append(order, i)
+				}
+			}
+			err = buffered.inOrder(order, emit)
+		} else {
+			err = buffered.each(func(i int, res Result) {
+				if keep[i] {
+					emit(res)
+				}
+			})
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: reading buffered results:", err)
+		}
+	}
+
+	if cp != nil {
+		close(stopCP)
+		<-cpDone
+	}
+
+	closeOutputs()
+
+	if hook != nil {
+		hook.Close()
+	}
+
+	if kafkaOut != nil {
+		if err := kafkaOut.Close(); err != nil {
+			warnf("Warning: kafka flush: %v\n", err)
+		}
+	}
+
+	if dash != nil {
+		close(stopDash)
+		<-dashDone
+		_, _ = os.Stdout.Write(held.Bytes())
+	}
+
+	if *printStats {
+		fmt.Fprintln(os.Stderr, opts.stats.summary(time.Since(started)))
+	}
 }
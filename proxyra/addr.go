@@ -0,0 +1,57 @@
+package proxyra
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// CanonicalProxy rewrites an IPv6 proxy host into one standard form, so the
+// same proxy written two ways dedupes and parses: bracketed, compressed and in
+// lower case, as in socks5://[2001:db8::1]:1080. The scheme, credentials and
+// port are kept. An IPv6 address written without brackets is ambiguous; the
+// last group is read as the port when the rest is a valid address, so
+// 2001:db8::1:1080 becomes [2001:db8::1]:1080. Other addresses, and anything
+// that does not parse, are returned unchanged.
+func CanonicalProxy(proxy string) string {
+	scheme, rest := "", proxy
+	if i := strings.Index(proxy, "://"); i >= 0 {
+		scheme, rest = proxy[:i+3], proxy[i+3:]
+	}
+	userinfo := ""
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		userinfo, rest = rest[:at+1], rest[at+1:]
+	}
+	host, port, ok := splitIPv6HostPort(rest)
+	if !ok {
+		return proxy
+	}
+	return scheme + userinfo + net.JoinHostPort(host, port)
+}
+
+// splitIPv6HostPort splits an IPv6 host:port, bracketed or not, returning the
+// address in canonical form
+func splitIPv6HostPort(hostport string) (host, port string, ok bool) {
+	if strings.HasPrefix(hostport, "[") {
+		h, p, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return "", "", false
+		}
+		host, port = h, p
+	} else {
+		if strings.Count(hostport, ":") < 2 {
+			return "", "", false
+		}
+		i := strings.LastIndex(hostport, ":")
+		host, port = hostport[:i], hostport[i+1:]
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.Is6() {
+		return "", "", false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", false
+	}
+	return addr.String(), port, true
+}
@@ -0,0 +1,42 @@
+package proxyra
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanonicalProxy(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"socks5://[2001:DB8:0:0::1]:1080", "socks5://[2001:db8::1]:1080"},
+		{"2001:db8::1:1080", "[2001:db8::1]:1080"},
+		{"http://2001:0db8::0001:8080", "http://[2001:db8::1]:8080"},
+		{"http://u:p@ss@[2001:db8::1]:8080", "http://u:p@ss@[2001:db8::1]:8080"},
+		{"http://user:pw@2001:db8::1:8080", "http://user:pw@[2001:db8::1]:8080"},
+		{"[::ffff:192.0.2.1]:80", "[::ffff:192.0.2.1]:80"},
+		// left alone: IPv4, names, no port, bad ports, not an address
+		{"http://192.0.2.1:80", "http://192.0.2.1:80"},
+		{"socks5://proxy.example:1080", "socks5://proxy.example:1080"},
+		{"http://[2001:db8::1]", "http://[2001:db8::1]"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]:99999", "[2001:db8::1]:99999"},
+		{"vmess://eyJ2IjoiMiJ9", "vmess://eyJ2IjoiMiJ9"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalProxy(tt.in); got != tt.want {
+			t.Errorf("CanonicalProxy(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUniqProxiesIPv6(t *testing.T) {
+	got := UniqProxies([]string{
+		"socks5://[2001:DB8::1]:1080",
+		"socks5://2001:db8:0::1:1080",
+		"http://[2001:db8::1]:1080",
+		"socks5://[2001:db8::1]:1080",
+	})
+	want := []string{"socks5://[2001:db8::1]:1080", "http://[2001:db8::1]:1080"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("UniqProxies = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+package proxyra
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Failure categories reported in Result.Reason
+const (
+	ReasonTimeout        = "timeout"
+	ReasonRequest        = "request_error"
+	ReasonDial           = "dial_error" // the proxy itself could not be reached
+	ReasonBadStatus      = "bad_status"
+	ReasonNoMatch        = "no_match"
+	ReasonHeaderTooLarge = "header_too_large"
+	ReasonTLSVerify      = "tls_verify_failed"
+	ReasonAuth           = "auth_failed" // the proxy refused its credentials, or wanted some
+)
+
+// ErrProxyAuth is wrapped by the errors of proxy handshakes that failed on
+// authentication
+var ErrProxyAuth = errors.New("proxy authentication failed")
+
+// readLimit is how much of a response body is matched against Options.Match
+const readLimit = 64 * 1024
+
+// Options configures a Checker
+type Options struct {
+	// Target is the http:// or https:// URL fetched through each proxy, or
+	// any URL Transport can fetch when that is set
+	Target string
+	// Method is the request method; empty means GET. A HEAD the server
+	// refuses with 405 or 501 is retried as GET.
+	Method string
+	// Body is sent with every attempt; nil sends none
+	Body []byte
+	// Match must match the response headers or the start of its body; nil
+	// accepts any response
+	Match *regexp.Regexp
+	// Statuses lists the accepted status codes; empty accepts any
+	Statuses []int
+	// Timeout bounds each request as a whole; 0 means 5 seconds
+	Timeout time.Duration
+	// Header is sent with every request
+	Header http.Header
+	// Retry is consulted after a request fails without a response; nil
+	// means no retries
+	Retry RetryPolicy
+
+	// Wait, when set, is called before every request, the first one before
+	// Timeout starts, to pace requests to the target; an error ends the check
+	Wait func(ctx context.Context) error
+	// CheckRedirect is the http.Client redirect policy; nil follows up to 10
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+	// Transport, when set, builds the round tripper for a proxy in place of
+	// NewTransport with TransportOptions
+	Transport func(proxy string) (http.RoundTripper, error)
+	// Evaluate, when set, replaces the Statuses and Match tests: it gets
+	// every response other than a 407 and sets res.OK and res.Reason. The
+	// body is closed once it returns.
+	Evaluate func(resp *http.Response, res *Result)
+
+	// Transport settings; DialTimeout defaults to Timeout
+	TransportOptions
+}
+
+// Result is the outcome of checking one proxy
+type Result struct {
+	Proxy      string
+	OK         bool
+	Reason     string // one of the Reason constants when not OK
+	StatusCode int
+	Latency    time.Duration // until the response headers arrived
+	Dial       time.Duration // part of Latency spent connecting through the proxy, handshakes included
+	Err        error         // the failed request behind Reason, nil once a response arrived
+}
+
+// Checker checks proxies against one target. It is safe for concurrent use.
+type Checker struct {
+	opts Options
+}
+
+// New returns a Checker for opts, or an error if the target is unusable
+func New(opts Options) (*Checker, error) {
+	u, err := url.Parse(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Transport == nil && u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("target must be an http or https URL, got %q", opts.Target)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = opts.Timeout
+	}
+	return &Checker{opts: opts}, nil
+}
+
+// CheckProxy checks a single proxy with a one-off Checker
+func CheckProxy(ctx context.Context, proxy string, opts Options) (Result, error) {
+	c, err := New(opts)
+	if err != nil {
+		return Result{Proxy: proxy}, err
+	}
+	return c.Check(ctx, proxy)
+}
+
+// Check fetches the target through proxy and reports whether the proxy
+// works. A proxy that fails the check is not an error: its Result says why.
+// The error is for proxy addresses that cannot be used at all.
+func (c *Checker) Check(ctx context.Context, proxy string) (Result, error) {
+	res := Result{Proxy: proxy}
+	var rt http.RoundTripper
+	var err error
+	if c.opts.Transport != nil {
+		rt, err = c.opts.Transport(proxy)
+	} else {
+		rt, err = NewTransport(proxy, c.opts.TransportOptions)
+	}
+	if err != nil {
+		return res, err
+	}
+	client := &http.Client{Transport: rt, CheckRedirect: c.opts.CheckRedirect}
+	defer client.CloseIdleConnections()
+
+	if err := c.wait(ctx); err != nil {
+		res.Reason, res.Err = ErrorReason(err), err
+		return res, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	method := c.opts.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if c.opts.Body != nil {
+		body = bytes.NewReader(c.opts.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.opts.Target, body)
+	if err != nil {
+		return res, err
+	}
+	for name, values := range c.opts.Header {
+		req.Header[name] = slices.Clone(values)
+	}
+
+	// Time connection setup separately: for SOCKS proxies it covers the
+	// handshake, which is often where a slow proxy loses its time
+	var dialStart time.Time
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { dialStart = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				res.Dial = time.Since(dialStart)
+			}
+		},
+	}))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	for attempt := 1; err != nil && c.opts.Retry != nil; attempt++ {
+		delay, again := c.opts.Retry.NextDelay(attempt, err)
+		if !again {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err = c.wait(ctx); err != nil {
+			break
+		}
+		rewind(req)
+		start = time.Now()
+		resp, err = client.Do(req)
+	}
+	// Some servers refuse HEAD; fall back to the GET the check would have sent
+	if err == nil && req.Method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		req = req.Clone(req.Context())
+		req.Method = http.MethodGet
+		rewind(req)
+		if err = c.wait(ctx); err == nil {
+			start = time.Now()
+			resp, err = client.Do(req)
+		}
+	}
+	if err != nil {
+		res.Reason, res.Err = ErrorReason(err), err
+		return res, nil
+	}
+	defer resp.Body.Close()
+	res.Latency = time.Since(start)
+	res.StatusCode = resp.StatusCode
+
+	// only a proxy answers 407, so it is about the proxy's credentials, not the target
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		res.Reason = ReasonAuth
+		return res, nil
+	}
+	if c.opts.Evaluate != nil {
+		c.opts.Evaluate(resp, &res)
+		return res, nil
+	}
+
+	if len(c.opts.Statuses) > 0 && !slices.Contains(c.opts.Statuses, resp.StatusCode) {
+		res.Reason = ReasonBadStatus
+		return res, nil
+	}
+	if c.opts.Match != nil {
+		headers, _ := httputil.DumpResponse(resp, false)
+		var body bytes.Buffer
+		_, _ = io.CopyN(&body, resp.Body, readLimit)
+		if !c.opts.Match.Match(append(headers, body.Bytes()...)) {
+			res.Reason = ReasonNoMatch
+			return res, nil
+		}
+	}
+	res.OK = true
+	return res, nil
+}
+
+// wait runs Options.Wait, if any
+func (c *Checker) wait(ctx context.Context) error {
+	if c.opts.Wait == nil {
+		return nil
+	}
+	return c.opts.Wait(ctx)
+}
+
+// rewind gives req a fresh copy of its body before it is sent again
+func rewind(req *http.Request) {
+	if req.GetBody != nil {
+		req.Body, _ = req.GetBody()
+	}
+}
+
+// ErrorReason maps an error that left no response onto its failure category
+func ErrorReason(err error) string {
+	var netErr net.Error
+	var opErr *net.OpError
+	var certErr *tls.CertificateVerificationError
+	switch {
+	// net/http reports oversized headers only through the error text
+	case strings.Contains(err.Error(), "server response headers exceeded"):
+		return ReasonHeaderTooLarge
+	case errors.As(err, &certErr):
+		// a bad certificate behind the proxy points at interception, not a dead proxy
+		return ReasonTLSVerify
+	// net/http turns a 407 answer to CONNECT into an error carrying just the status text
+	case errors.Is(err, ErrProxyAuth), strings.Contains(err.Error(), "Proxy Authentication Required"):
+		return ReasonAuth
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	case errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect"):
+		return ReasonDial
+	default:
+		return ReasonRequest
+	}
+}
+
+// UniqProxies returns proxies without duplicates, keeping the first of each
+// in order. Addresses are compared, and returned, in their CanonicalProxy
+// form, so [2001:DB8::1]:1080 and 2001:db8::1:1080 are one proxy.
+func UniqProxies(proxies []string) []string {
+	seen := make(map[string]struct{}, len(proxies))
+	out := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		p = CanonicalProxy(p)
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			out = append(out, p)
+		}
+	}
+	return out
+}
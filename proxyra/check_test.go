@@ -0,0 +1,106 @@
+package proxyra
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// the test server is reached through itself: an HTTP proxy sees the same
+// absolute-URI requests an origin would, so its handler can answer both
+
+func TestCheckHeadFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		io.WriteString(w, "welcome")
+	}))
+	defer srv.Close()
+	res, err := CheckProxy(context.Background(), srv.URL, Options{
+		Target: srv.URL + "/",
+		Method: http.MethodHead,
+		Match:  regexp.MustCompile("welcome"),
+	})
+	if err != nil || !res.OK {
+		t.Fatalf("ok=%v reason=%s err=%v, want the GET fallback to pass", res.OK, res.Reason, err)
+	}
+}
+
+func TestCheckRetryResendsBody(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if attempts.Add(1) == 1 {
+			// drop the connection so the first attempt gets no response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		io.WriteString(w, string(body))
+	}))
+	defer srv.Close()
+	var waits atomic.Int32
+	res, err := CheckProxy(context.Background(), srv.URL, Options{
+		Target: srv.URL + "/",
+		Method: http.MethodPost,
+		Body:   []byte("q=proxyra"),
+		Match:  regexp.MustCompile("q=proxyra"),
+		Retry:  ConstantBackoff{Delay: time.Millisecond, Attempts: 2},
+		Wait: func(context.Context) error {
+			waits.Add(1)
+			return nil
+		},
+	})
+	if err != nil || !res.OK || attempts.Load() != 2 {
+		t.Fatalf("ok=%v reason=%s err=%v after %d attempts, want the body echoed by the retry", res.OK, res.Reason, err, attempts.Load())
+	}
+	if waits.Load() != 2 {
+		t.Fatalf("Wait called %d times, want once per request", waits.Load())
+	}
+}
+
+func TestCheckEvaluate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Verdict", "bad")
+		io.WriteString(w, "welcome")
+	}))
+	defer srv.Close()
+	res, err := CheckProxy(context.Background(), srv.URL, Options{
+		Target: srv.URL + "/",
+		Match:  regexp.MustCompile("welcome"),
+		Evaluate: func(resp *http.Response, res *Result) {
+			res.OK = resp.Header.Get("X-Verdict") == "good"
+			if !res.OK {
+				res.Reason = ReasonNoMatch
+			}
+		},
+	})
+	if err != nil || res.OK || res.Reason != ReasonNoMatch || res.StatusCode != http.StatusOK {
+		t.Fatalf("ok=%v reason=%s status=%d err=%v, want Evaluate's verdict", res.OK, res.Reason, res.StatusCode, err)
+	}
+
+	// a custom transport may fetch targets NewTransport could not
+	local := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("welcome")), Request: req}, nil
+	})
+	res, err = CheckProxy(context.Background(), "unused", Options{
+		Target:    "data:,welcome",
+		Match:     regexp.MustCompile("welcome"),
+		Transport: func(string) (http.RoundTripper, error) { return local, nil },
+	})
+	if err != nil || !res.OK {
+		t.Fatalf("ok=%v reason=%s err=%v through a custom Transport", res.OK, res.Reason, err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
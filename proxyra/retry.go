@@ -0,0 +1,82 @@
+// Package proxyra holds the parts of the proxy checker that are usable from
+// other Go programs.
+package proxyra
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is tried again. NextDelay is
+// called after the attempt-th failure (starting at 1) with its error and
+// returns how long to wait before the next try, or false to give up.
+type RetryPolicy interface {
+	NextDelay(attempt int, err error) (time.Duration, bool)
+}
+
+// RetryFunc adapts a function to RetryPolicy
+type RetryFunc func(attempt int, err error) (time.Duration, bool)
+
+func (f RetryFunc) NextDelay(attempt int, err error) (time.Duration, bool) {
+	return f(attempt, err)
+}
+
+// ConstantBackoff retries up to Attempts times, waiting Delay before each
+type ConstantBackoff struct {
+	Delay    time.Duration
+	Attempts int
+}
+
+func (b ConstantBackoff) NextDelay(attempt int, _ error) (time.Duration, bool) {
+	return b.Delay, attempt <= b.Attempts
+}
+
+// LinearBackoff retries up to Attempts times, waiting Step, 2*Step, 3*Step
+// and so on
+type LinearBackoff struct {
+	Step     time.Duration
+	Attempts int
+}
+
+func (b LinearBackoff) NextDelay(attempt int, _ error) (time.Duration, bool) {
+	return b.Step * time.Duration(attempt), attempt <= b.Attempts
+}
+
+// ExponentialBackoff retries up to Attempts times, waiting Base, 2*Base,
+// 4*Base and so on, capped at Max when Max is set
+type ExponentialBackoff struct {
+	Base     time.Duration
+	Max      time.Duration
+	Attempts int
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int, _ error) (time.Duration, bool) {
+	if attempt > b.Attempts {
+		return 0, false
+	}
+	d := b.Base << min(attempt-1, 30)
+	if b.Max > 0 && (d > b.Max || d < 0) {
+		d = b.Max
+	}
+	return d, true
+}
+
+// JitteredBackoff is ExponentialBackoff with "full jitter": each wait is
+// drawn uniformly from [0, exponential delay), which spreads out retries
+// from many workers hitting the same failure. Rand may be set for
+// reproducible delays and must then be safe for concurrent use.
+type JitteredBackoff struct {
+	ExponentialBackoff
+	Rand *rand.Rand
+}
+
+func (b JitteredBackoff) NextDelay(attempt int, err error) (time.Duration, bool) {
+	d, ok := b.ExponentialBackoff.NextDelay(attempt, err)
+	if !ok || d <= 0 {
+		return d, ok
+	}
+	if b.Rand != nil {
+		return time.Duration(b.Rand.Int64N(int64(d))), true
+	}
+	return time.Duration(rand.Int64N(int64(d))), true
+}
@@ -0,0 +1,77 @@
+package proxyra
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// delays collects the waits p asks for until it gives up, up to 10
+func delays(p RetryPolicy) []time.Duration {
+	var ds []time.Duration
+	for attempt := 1; attempt <= 10; attempt++ {
+		d, ok := p.NextDelay(attempt, nil)
+		if !ok {
+			break
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func TestBackoffs(t *testing.T) {
+	const s = time.Second
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{"constant", ConstantBackoff{Delay: s, Attempts: 3}, []time.Duration{s, s, s}},
+		{"linear", LinearBackoff{Step: s, Attempts: 3}, []time.Duration{s, 2 * s, 3 * s}},
+		{"exponential", ExponentialBackoff{Base: s, Attempts: 4}, []time.Duration{s, 2 * s, 4 * s, 8 * s}},
+		{"capped", ExponentialBackoff{Base: s, Max: 3 * s, Attempts: 4}, []time.Duration{s, 2 * s, 3 * s, 3 * s}},
+		{"none", ConstantBackoff{Delay: s}, nil},
+	}
+	for _, tt := range tests {
+		if got := delays(tt.policy); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: delays %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a shift past the width of Duration must cap, not wrap
+	if d, ok := (ExponentialBackoff{Base: time.Hour, Max: 5 * time.Minute, Attempts: 100}).NextDelay(100, nil); !ok || d != 5*time.Minute {
+		t.Errorf("overflowed delay = %v, %v", d, ok)
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: 100 * time.Millisecond, Attempts: 5}
+	seeded := func() []time.Duration {
+		return delays(JitteredBackoff{ExponentialBackoff: b, Rand: rand.New(rand.NewPCG(7, 7))})
+	}
+	got := seeded()
+	if len(got) != 5 {
+		t.Fatalf("%d retries, want 5", len(got))
+	}
+	for i, d := range got {
+		if limit := b.Base << i; d < 0 || d >= limit {
+			t.Errorf("attempt %d waited %v, want [0, %v)", i+1, d, limit)
+		}
+	}
+	if !slices.Equal(got, seeded()) {
+		t.Error("the same seed gave different delays")
+	}
+	if d, ok := (JitteredBackoff{}).NextDelay(1, nil); ok || d != 0 {
+		t.Errorf("zero value: %v, %v", d, ok)
+	}
+}
+
+func TestRetryFunc(t *testing.T) {
+	var p RetryPolicy = RetryFunc(func(attempt int, _ error) (time.Duration, bool) {
+		return time.Duration(attempt) * time.Millisecond, attempt < 3
+	})
+	if got := delays(p); !slices.Equal(got, []time.Duration{time.Millisecond, 2 * time.Millisecond}) {
+		t.Fatalf("delays %v", got)
+	}
+}
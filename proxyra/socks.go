@@ -0,0 +1,147 @@
+package proxyra
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// socks4Connect runs a SOCKS4 CONNECT for addr over an established conn.
+// SOCKS4 needs an IPv4 address, so names are resolved here unless socks4a
+// lets the proxy resolve them.
+func socks4Connect(conn net.Conn, addr, user string, socks4a bool) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	req := binary.BigEndian.AppendUint16([]byte{4, 1}, uint16(port))
+	ip := net.ParseIP(host).To4()
+	if ip == nil && !socks4a {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return err
+		}
+		for _, cand := range ips {
+			if ip = cand.To4(); ip != nil {
+				break
+			}
+		}
+		if ip == nil {
+			return fmt.Errorf("no IPv4 address for %s", host)
+		}
+	}
+	if ip != nil {
+		req = append(append(req, ip...), user...)
+		req = append(req, 0)
+	} else {
+		// 0.0.0.1 tells a SOCKS4a proxy that a hostname follows the user id
+		req = append(append(req, 0, 0, 0, 1), user...)
+		req = append(append(append(req, 0), strings.TrimSuffix(host, ".")...), 0)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x5a {
+		return fmt.Errorf("SOCKS4 CONNECT failed with code %#x", reply[1])
+	}
+	return nil
+}
+
+// socks5Connect runs a SOCKS5 CONNECT for addr over an established conn,
+// offering username/password auth when user is set
+func socks5Connect(conn net.Conn, addr string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0)
+	if user != nil {
+		method = 2
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("SOCKS5 greeting refused")
+	}
+	if reply[1] != method {
+		// the server wants credentials we lack, or will not take ours
+		return fmt.Errorf("SOCKS5 auth method %d refused: %w", method, ErrProxyAuth)
+	}
+	if method == 2 {
+		pass, _ := user.Password()
+		auth := []byte{1, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("SOCKS5 login: %w", ErrProxyAuth)
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 1), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 4), ip.To16()...)
+	} else {
+		req = append(append(req, 3, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// reply: version, status, reserved, then a bound address we discard
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("SOCKS5 CONNECT failed with code %d", head[1])
+	}
+	skip := 0
+	switch head[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("SOCKS5 reply has address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,283 @@
+package proxyra
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"h12.io/socks"
+)
+
+// DialFunc opens a plain TCP connection, like net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// TransportOptions configures NewTransport and DialTunnel
+type TransportOptions struct {
+	// DialTimeout caps connecting to the proxy: the TCP connect, plus the
+	// handshake for SOCKS proxies. 0 leaves it to the caller's context.
+	DialTimeout time.Duration
+	// Insecure skips verification of the target's TLS certificate
+	Insecure bool
+	// MaxHeaderBytes limits the size of response headers; 0 is net/http's default
+	MaxHeaderBytes int64
+	// Dial opens connections to the proxy, or to the target when there is
+	// none. nil uses a zero net.Dialer.
+	Dial DialFunc
+	// DefaultScheme is given to proxies written without one, e.g. "http";
+	// empty means socks5
+	DefaultScheme string
+}
+
+// dial opens a TCP connection through o.Dial or a zero net.Dialer
+func (o TransportOptions) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o.Dial != nil {
+		return o.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// withDialTimeout bounds ctx by o.DialTimeout, when set
+func (o TransportOptions) withDialTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.DialTimeout > 0 {
+		return context.WithTimeout(ctx, o.DialTimeout)
+	}
+	return ctx, func() {}
+}
+
+// normalizeProxy gives a scheme-less proxy like "1.2.3.4:1080" the scheme
+// o.DefaultScheme, or socks5, the most common choice for bare addresses, and
+// brackets IPv6 hosts
+func (o TransportOptions) normalizeProxy(proxy string) string {
+	proxy = CanonicalProxy(proxy)
+	if !strings.Contains(proxy, "://") {
+		scheme := o.DefaultScheme
+		if scheme == "" {
+			scheme = "socks5"
+		}
+		return scheme + "://" + proxy
+	}
+	return proxy
+}
+
+// NewTransport returns a transport that sends every request through proxy,
+// an http://, https://, socks4://, socks4a:// or socks5:// URL. A proxy
+// without a scheme is taken as o.DefaultScheme. An empty proxy connects
+// directly.
+// Keep-alives are off, so each request gets a fresh connection through the
+// proxy.
+func NewTransport(proxy string, o TransportOptions) (*http.Transport, error) {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: o.Insecure,
+			MinVersion:         tls.VersionTLS12,
+		},
+		DisableCompression:  false,
+		MaxIdleConns:        0,
+		IdleConnTimeout:     0,
+		MaxIdleConnsPerHost: -1,
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: 10 * time.Second,
+
+		MaxResponseHeaderBytes: o.MaxHeaderBytes,
+	}
+
+	// the connect gets no more than the dial timeout, the request as a whole its own deadline
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := o.withDialTimeout(ctx)
+		defer cancel()
+		return o.dial(ctx, network, addr)
+	}
+
+	// an empty proxy address means a direct connection, used for baseline fetches
+	if proxy == "" {
+		return transport, nil
+	}
+
+	u, err := url.Parse(o.normalizeProxy(proxy))
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		transport.Proxy = http.ProxyURL(u)
+
+	case "socks4", "socks4a", "socks5":
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// The SOCKS connect and handshake get the dial timeout, or less
+			// if the caller's deadline comes first.
+			ctx, cancel := o.withDialTimeout(ctx)
+			defer cancel()
+			return dialSOCKS(ctx, u, addr, o)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	return transport, nil
+}
+
+// DialTunnel opens a TCP connection to target (host:port) through proxy:
+// a CONNECT tunnel for HTTP proxies, a SOCKS CONNECT otherwise. ctx bounds
+// setting the tunnel up; the returned connection has no deadline.
+func DialTunnel(ctx context.Context, proxy, target string, o TransportOptions) (net.Conn, error) {
+	u, err := url.Parse(o.normalizeProxy(proxy))
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "socks4", "socks4a", "socks5":
+		return dialSOCKS(ctx, u, target, o)
+
+	case "http", "https":
+		dctx, cancel := o.withDialTimeout(ctx)
+		proxyConn, err := o.dial(dctx, "tcp", u.Host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			creds := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+			connectReq += "Proxy-Authorization: Basic " + creds + "\r\n"
+		}
+		connectReq += "\r\n"
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = proxyConn.SetDeadline(deadline)
+		}
+		_, err = proxyConn.Write([]byte(connectReq))
+		if err != nil {
+			proxyConn.Close()
+			return nil, err
+		}
+
+		br := bufio.NewReader(proxyConn)
+		line, err := br.ReadString('\n')
+		if err != nil {
+			proxyConn.Close()
+			return nil, err
+		}
+
+		// Parse HTTP status line properly
+		parts := strings.Fields(line)
+		if len(parts) >= 2 && parts[1] == "407" {
+			proxyConn.Close()
+			return nil, fmt.Errorf("CONNECT rejected: %s: %w", strings.TrimSpace(line), ErrProxyAuth)
+		}
+		if len(parts) < 2 || (parts[1] != "200" && !strings.HasPrefix(parts[1], "2")) {
+			proxyConn.Close()
+			return nil, fmt.Errorf("CONNECT rejected: %s", strings.TrimSpace(line))
+		}
+
+		// read until empty line (end of headers)
+		for {
+			line, err = br.ReadString('\n')
+			if err != nil {
+				proxyConn.Close()
+				return nil, err
+			}
+			if line == "\r\n" || line == "\n" {
+				break
+			}
+		}
+
+		_ = proxyConn.SetDeadline(time.Time{})
+		if br.Buffered() > 0 {
+			// the target spoke first and its bytes arrived with the reply
+			return &bufferedConn{Conn: proxyConn, r: br}, nil
+		}
+		return proxyConn, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+}
+
+// bufferedConn is a tunnel whose first bytes were read along with the
+// CONNECT reply
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// dialSOCKS connects to addr through the SOCKS proxy u, giving up when ctx
+// ends. Without a custom Dial this is h12.io/socks; with one, the proxy
+// connection comes from it and the SOCKS handshake is done here, since that
+// library cannot dial through anything else.
+func dialSOCKS(ctx context.Context, u *url.URL, addr string, o TransportOptions) (net.Conn, error) {
+	if o.Dial != nil {
+		conn, err := o.Dial(ctx, "tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		if u.Scheme == "socks5" {
+			err = socks5Connect(conn, addr, u.User)
+		} else {
+			err = socks4Connect(conn, addr, u.User.Username(), u.Scheme == "socks4a")
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+
+	// h12.io/socks takes no context, so it runs aside and a connection that
+	// arrives after the caller gave up is closed rather than leaked
+	dialSocks := socks.Dial(u.String())
+	ch := make(chan struct {
+		conn net.Conn
+		err  error
+	}, 1)
+
+	go func() {
+		conn, err := dialSocks("tcp", addr)
+		if err != nil && socksAuthError(err) {
+			err = fmt.Errorf("%w: %v", ErrProxyAuth, err)
+		}
+		select {
+		case ch <- struct {
+			conn net.Conn
+			err  error
+		}{conn: conn, err: err}:
+		case <-ctx.Done():
+			if err == nil && conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.conn, r.err
+	}
+}
+
+// socksAuthError reports whether an h12.io/socks error is an authentication
+// failure; that library only tells them apart by message. A refused method
+// negotiation means the server wanted credentials we lack, or will not take
+// the kind we offered.
+func socksAuthError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "user/password login failed") || strings.Contains(msg, "socks method negotiation failed")
+}
@@ -0,0 +1,756 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+)
+
+// TestMain lets runProxyra run the test binary as the proxyra command
+func TestMain(m *testing.M) {
+	if os.Getenv("PROXYRA_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runProxyra runs proxyra with args, feeding it stdin, and returns what it
+// printed and its exit code
+func runProxyra(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "PROXYRA_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+// testOptions returns the options of a quiet run checking target for re
+func testOptions(target, re string) *checkOptions {
+	return &checkOptions{
+		target:       target,
+		timeout:      5,
+		re:           regexp.MustCompile(re),
+		maxRedirects: 10,
+		readLimit:    1 << 20,
+		quiet:        true,
+		stderrMutex:  &sync.Mutex{},
+		ctx:          context.Background(),
+	}
+}
+
+// startProxy runs a stub proxy of scheme for the length of the test
+func startProxy(t *testing.T, scheme string) string {
+	t.Helper()
+	proxy, stop, err := startStubProxy(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	return proxy
+}
+
+func TestProbeLocation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/landing", http.StatusFound)
+			return
+		}
+		w.Write([]byte("landed"))
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	opts := testOptions(srv.URL+"/start", "landed")
+	opts.probeLocation = true
+	res := checkProxyHTTP(proxy, opts)
+	if res.OK || res.StatusCode != http.StatusFound {
+		t.Fatalf("redirect was followed: ok=%v status=%d", res.OK, res.StatusCode)
+	}
+	if res.Location != srv.URL+"/landing" {
+		t.Fatalf("location = %q", res.Location)
+	}
+	if c := res.LocationCheck; c == nil || !c.OK || c.StatusCode != http.StatusOK {
+		t.Fatalf("location check = %+v", c)
+	}
+
+	got, err := unmarshalResult(marshalResult(res))
+	if err != nil || got.LocationCheck == nil || *got.LocationCheck != *res.LocationCheck {
+		t.Fatalf("proto round trip = %+v, %v", got.LocationCheck, err)
+	}
+}
+
+// startAuthHTTPProxy runs a forwarding HTTP proxy that answers 407 unless
+// the request carries user and pass
+func startAuthHTTPProxy(t *testing.T, user, pass string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		maps.Copy(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// startAuthSOCKS5 runs a SOCKS5 proxy that only accepts user and pass
+// (RFC 1929), taking CONNECT requests by IPv4 address or by name
+func startAuthSOCKS5(t *testing.T, user, pass string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 512)
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 2})
+				io.ReadFull(conn, buf[:2])
+				u := make([]byte, buf[1])
+				io.ReadFull(conn, u)
+				io.ReadFull(conn, buf[:1])
+				p := make([]byte, buf[0])
+				io.ReadFull(conn, p)
+				if string(u) != user || string(p) != pass {
+					conn.Write([]byte{1, 1})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{1, 0})
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+					conn.Close()
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				default:
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:2])
+				addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				splice(conn, upstream)
+			}()
+		}
+	}()
+	return "socks5://" + ln.Addr().String()
+}
+
+func TestNormalizeCredentials(t *testing.T) {
+	const user, pass = "us%er", "p@ss:w%rd/1"
+	target := startTextServer(t, "through")
+	for name, proxy := range map[string]string{
+		"http":   startAuthHTTPProxy(t, user, pass),
+		"socks5": startAuthSOCKS5(t, user, pass),
+	} {
+		scheme, host, _ := strings.Cut(proxy, "://")
+		raw := scheme + "://" + user + ":" + pass + "@" + host
+		if _, err := url.Parse(raw); err == nil {
+			t.Fatalf("%s: %q parses without normalizing, the test proves nothing", name, raw)
+		}
+		normalized := normalizeCredentials(raw)
+		u, err := url.Parse(normalized)
+		if err != nil {
+			t.Fatalf("%s: %q: %v", name, normalized, err)
+		}
+		if p, _ := u.User.Password(); u.User.Username() != user || p != pass || u.Host != host {
+			t.Fatalf("%s: %q parses as %s:%s@%s", name, normalized, u.User.Username(), p, u.Host)
+		}
+		if res := checkProxyHTTP(normalized, testOptions(target, "through")); !res.OK {
+			t.Fatalf("%s: check through %q failed: %s", name, normalized, res.Reason)
+		}
+		// the stubs really check the credentials
+		wrong := scheme + "://" + url.UserPassword(user, "nope").String() + "@" + host
+		if res := checkProxyHTTP(wrong, testOptions(target, "through")); res.OK {
+			t.Fatalf("%s: wrong password passed", name)
+		}
+	}
+	if got := normalizeCredentials("http://1.2.3.4:80"); got != "http://1.2.3.4:80" {
+		t.Fatalf("address without credentials changed to %q", got)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				http.ReadRequest(bufio.NewReader(conn))
+				w := bufio.NewWriter(conn)
+				w.WriteString("HTTP/1.1 200 OK\r\n")
+				for i := range 1000 {
+					fmt.Fprintf(w, "X-Flood-%d: %s\r\n", i, strings.Repeat("a", 60))
+				}
+				w.WriteString("Content-Length: 2\r\nConnection: close\r\n\r\nok")
+				w.Flush()
+			}()
+		}
+	}()
+	target := "http://" + ln.Addr().String() + "/"
+	proxy := startProxy(t, "socks5")
+
+	opts := testOptions(target, "ok")
+	opts.maxHeaderBytes = 16 << 10
+	if res := checkProxyHTTP(proxy, opts); res.OK || res.Reason != reasonHeaderTooLarge {
+		t.Fatalf("64 KB of headers under a 16 KB cap: ok=%v reason=%s", res.OK, res.Reason)
+	}
+	opts.maxHeaderBytes = defaultMaxHeaderBytes
+	if res := checkProxyHTTP(proxy, opts); !res.OK {
+		t.Fatalf("64 KB of headers under the default cap: %s", res.Reason)
+	}
+}
+
+// startDivertingProxy runs an HTTP proxy whose CONNECT tunnels all lead to
+// addr, whatever the client asked for; plain requests are forwarded
+func startDivertingProxy(t *testing.T, addr string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					conn.Close()
+					return
+				}
+				if req.Method != http.MethodConnect {
+					defer conn.Close()
+					req.RequestURI = ""
+					if resp, err := http.DefaultTransport.RoundTrip(req); err == nil {
+						resp.Write(conn)
+						resp.Body.Close()
+					}
+					return
+				}
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				splice(conn, upstream)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestDetectSSLStrip(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	defer secure.Close()
+	// terminates TLS with its own certificate and sends the client to plain http
+	mitm := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer mitm.Close()
+
+	opts := testOptions(secure.URL+"/", "welcome")
+	opts.insecure = true
+	opts.detectSSLStrip = true
+	if res := checkProxyHTTP(startProxy(t, "http"), opts); !res.OK {
+		t.Fatalf("honest proxy: ok=%v reason=%s", res.OK, res.Reason)
+	}
+	stripping := startDivertingProxy(t, mitm.Listener.Addr().String())
+	if res := checkProxyHTTP(stripping, opts); res.OK || res.Reason != reasonSSLStrip {
+		t.Fatalf("stripping proxy: ok=%v reason=%s", res.OK, res.Reason)
+	}
+	opts.detectSSLStrip = false
+	if res := checkProxyHTTP(stripping, opts); !res.OK || res.EffectiveURL != plain.URL+"/" {
+		t.Fatalf("stripping proxy without -detect-ssl-strip: ok=%v reason=%s url=%s", res.OK, res.Reason, res.EffectiveURL)
+	}
+}
+
+// startResettingProxy runs an HTTP proxy that resets its first n connections
+// once the request has arrived and serves the rest as the stub does
+func startResettingProxy(t *testing.T, n int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if accepted.Add(1) > n {
+				go serveStubHTTP(conn)
+				continue
+			}
+			go func() {
+				http.ReadRequest(bufio.NewReader(conn))
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestReconnectOnReset(t *testing.T) {
+	target := startTextServer(t, "after reset")
+	opts := testOptions(target, "after reset")
+
+	res := checkProxyHTTP(startResettingProxy(t, 1), opts)
+	if res.OK {
+		t.Fatal("reset connection passed without retries")
+	}
+
+	resets := proxyra.ConstantBackoff{Delay: time.Millisecond, Attempts: 2}
+	opts.retry = requestPolicy(resets, nil)
+	if res := checkProxyHTTP(startResettingProxy(t, 2), opts); !res.OK {
+		t.Fatalf("two resets with two retries: %s", res.Reason)
+	}
+	if res := checkProxyHTTP(startResettingProxy(t, 3), opts); res.OK {
+		t.Fatal("three resets passed with two retries")
+	}
+	// other failures are left to -retries
+	if res := checkProxyHTTP("http://127.0.0.1:1", opts); res.OK || res.Reason == "" {
+		t.Fatalf("refused connection: ok=%v reason=%q", res.OK, res.Reason)
+	}
+}
+
+func TestRequestPolicy(t *testing.T) {
+	reset := &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	refused := &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	resets := proxyra.ConstantBackoff{Delay: time.Second, Attempts: 1}
+	transient := proxyra.ConstantBackoff{Delay: time.Minute, Attempts: 1}
+
+	if requestPolicy(nil, nil) != nil {
+		t.Fatal("no policies should mean no retries")
+	}
+	p := requestPolicy(resets, nil)
+	if d, ok := p.NextDelay(1, reset); !ok || d != time.Second {
+		t.Fatalf("reset: %v, %v", d, ok)
+	}
+	if _, ok := p.NextDelay(1, refused); ok {
+		t.Fatal("refused connection retried by -reconnect-on-reset alone")
+	}
+	p = requestPolicy(resets, transient)
+	if d, ok := p.NextDelay(1, refused); !ok || d != time.Minute {
+		t.Fatalf("refused with -retries: %v, %v", d, ok)
+	}
+	if d, _ := p.NextDelay(1, reset); d != time.Second {
+		t.Fatalf("reset with both policies waited %v", d)
+	}
+}
+
+func TestDrainBody(t *testing.T) {
+	const size = 16 << 20
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	sent := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		n := 0
+		for n < size {
+			m, err := w.Write(chunk)
+			n += m
+			if err != nil {
+				break
+			}
+		}
+		sent <- n
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "socks5")
+
+	opts := testOptions(srv.URL, "x")
+	opts.readLimit = 1 << 10
+	opts.drainBody = true
+	if res := checkProxyHTTP(proxy, opts); !res.OK {
+		t.Fatalf("check failed: %s", res.Reason)
+	}
+	if n := <-sent; n != size {
+		t.Fatalf("with -drain-body the server sent %d of %d bytes", n, size)
+	}
+
+	opts.drainBody = false
+	if res := checkProxyHTTP(proxy, opts); !res.OK {
+		t.Fatalf("check failed: %s", res.Reason)
+	}
+	if n := <-sent; n == size {
+		t.Fatal("without -drain-body the whole body was still read")
+	}
+}
+
+func TestWarnDuplicateExit(t *testing.T) {
+	target := startTextServer(t, "ok")
+	echo := startTextServer(t, "203.0.113.5")
+	a, b, c := startProxy(t, "http"), startProxy(t, "http"), startProxy(t, "socks5")
+
+	stdout, stderr, code := runProxyra(t, a+"\n"+b+"\n"+c+"\n", "-u", target, "-r", "ok", "-c", "1", "-ip-echo-url", echo, "-warn-duplicate-exit")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	if n := strings.Count(strings.TrimSpace(stdout), "\n") + 1; n != 3 {
+		t.Fatalf("printed %d proxies, want all 3:\n%s", n, stdout)
+	}
+	if n := strings.Count(stderr, "shares exit IP 203.0.113.5 with "+a); n != 2 {
+		t.Fatalf("%d duplicate-exit warnings, want 2:\n%s", n, stderr)
+	}
+}
+
+func TestFallbackTargets(t *testing.T) {
+	var fallbackHits atomic.Int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits.Add(1)
+		w.Write([]byte("welcome"))
+	}))
+	defer fallback.Close()
+	blocked := startTextServer(t, "access denied")
+	proxy := startProxy(t, "http")
+
+	opts := testOptions(blocked, "welcome")
+	opts.fallbacks = []string{blocked, fallback.URL}
+	res := checkProxyHTTP(proxy, opts)
+	if !res.OK || res.Target != fallback.URL {
+		t.Fatalf("ok=%v target=%q reason=%s, want a pass on the fallback", res.OK, res.Target, res.Reason)
+	}
+
+	// a proxy that cannot be reached would fail every fallback too
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + ln.Addr().String()
+	ln.Close()
+	fallbackHits.Store(0)
+	if res := checkProxyHTTP(dead, opts); res.OK || res.Target != "" || fallbackHits.Load() != 0 {
+		t.Fatalf("dead proxy: ok=%v target=%q fallback hits=%d", res.OK, res.Target, fallbackHits.Load())
+	}
+}
+
+func TestStrictScheme(t *testing.T) {
+	target := startTextServer(t, "ok")
+	proxy := startProxy(t, "socks5")
+	bare := strings.TrimPrefix(proxy, "socks5://")
+
+	stdout, stderr, _ := runProxyra(t, bare+"\n", "-u", target, "-r", "ok")
+	if strings.TrimSpace(stdout) != bare {
+		t.Fatalf("without -strict-scheme the bare address should pass as socks5: %q %s", stdout, stderr)
+	}
+	stdout, stderr, _ = runProxyra(t, bare+"\n"+proxy+"\n", "-u", target, "-r", "ok", "-strict-scheme")
+	if strings.TrimSpace(stdout) != proxy || !strings.Contains(stderr, bare+" has no scheme") {
+		t.Fatalf("stdout=%q stderr=%s", stdout, stderr)
+	}
+}
+
+func TestQuietErrorsOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/landing", http.StatusFound)
+			return
+		}
+		w.Write([]byte("203.0.113.5"))
+	}))
+	defer srv.Close()
+	a, b := startProxy(t, "http"), startProxy(t, "http")
+	// one line of each kind: an info line from -probe-location, a warning
+	// from -warn-duplicate-exit and an error from -strict-scheme
+	stdin := a + "\n" + b + "\n127.0.0.1:1\n"
+	args := []string{"-u", srv.URL + "/start", "-s", "302", "-r", ".", "-c", "1", "-probe-location",
+		"-ip-echo-url", srv.URL + "/landing", "-warn-duplicate-exit", "-strict-scheme"}
+
+	for _, tc := range []struct {
+		flags               []string
+		info, warning, fail bool
+	}{
+		{nil, true, true, true},
+		{[]string{"-quiet-errors-only"}, false, true, true},
+	} {
+		_, stderr, _ := runProxyra(t, stdin, append(args, tc.flags...)...)
+		got := [3]bool{strings.Contains(stderr, "Location probe:"), strings.Contains(stderr, "shares exit IP"), strings.Contains(stderr, "has no scheme")}
+		if got != [3]bool{tc.info, tc.warning, tc.fail} {
+			t.Errorf("%v: info, warning, error printed = %v\n%s", tc.flags, got, stderr)
+		}
+	}
+}
+
+func TestHeadFirst(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.URL.Path == "/no-head" && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	for path, want := range map[string][]string{
+		"/":        {http.MethodHead},
+		"/no-head": {http.MethodHead, http.MethodGet},
+	} {
+		methods = nil
+		opts := testOptions(srv.URL+path, "")
+		opts.headFirst = true
+		opts.policy = successPolicy{statuses: []int{http.StatusOK}}
+		res := checkProxyHTTP(proxy, opts)
+		if !res.OK || res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: ok=%v status=%d reason=%s", path, res.OK, res.StatusCode, res.Reason)
+		}
+		mu.Lock()
+		got := slices.Clone(methods)
+		mu.Unlock()
+		if !slices.Equal(got, want) {
+			t.Fatalf("%s: methods %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCanary(t *testing.T) {
+	target := startTextServer(t, "welcome")
+	// serveText answers proxy requests too, so this canary serves a block page
+	canary := strings.TrimSuffix(startTextServer(t, "access denied"), "/")
+	proxy := startProxy(t, "http")
+
+	stdout, stderr, code := runProxyra(t, proxy+"\n", "-u", target, "-r", ".", "-canary", canary)
+	if code != 1 || !strings.Contains(stderr, "canary "+canary+" passed") || stdout != "" {
+		t.Fatalf("loose regex: exit %d stdout=%q stderr=%s", code, stdout, stderr)
+	}
+	stdout, stderr, code = runProxyra(t, proxy+"\n", "-u", target, "-r", "welcome", "-canary", canary)
+	if code != 0 || strings.TrimSpace(stdout) != proxy {
+		t.Fatalf("strict regex: exit %d stdout=%q stderr=%s", code, stdout, stderr)
+	}
+}
+
+func TestPerProxyBudget(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-done:
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer srv.Close()
+	defer close(done)
+	proxy := startProxy(t, "http")
+
+	base := testOptions(srv.URL, "slow")
+	if base.forProxy(proxy) != base {
+		t.Fatal("forProxy copied the options without a budget or seed")
+	}
+	base.budget = 300 * time.Millisecond
+	opts := base.forProxy(proxy)
+	if opts == base || !base.deadline.IsZero() {
+		t.Fatal("the budget's deadline leaked into the shared options")
+	}
+	if d := opts.requestTimeout(); d > base.budget {
+		t.Fatalf("request timeout %s outlasts the budget", d)
+	}
+
+	start := time.Now()
+	res := checkProxyHTTP(proxy, opts)
+	if elapsed := time.Since(start); res.OK || elapsed > time.Second {
+		t.Fatalf("ok=%v after %s, want a failure within the 300ms budget", res.OK, elapsed)
+	}
+	if !opts.budgetSpent() || base.budgetSpent() {
+		t.Fatal("budgetSpent wrong after the check")
+	}
+}
+
+func TestDedupeByExitAndScheme(t *testing.T) {
+	target := startTextServer(t, "ok")
+	echo := startTextServer(t, "203.0.113.5")
+	a, b, c := startProxy(t, "http"), startProxy(t, "http"), startProxy(t, "socks5")
+
+	stdout, stderr, code := runProxyra(t, a+"\n"+b+"\n"+c+"\n", "-u", target, "-r", "ok", "-c", "1", "-ip-echo-url", echo, "-dedupe-by-exit-and-scheme")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	// all three share one exit; the second http proxy repeats a pair
+	if got := strings.Fields(stdout); !slices.Equal(got, []string{a, c}) {
+		t.Fatalf("printed %v, want %v", got, []string{a, c})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	_, stderr, code := runProxyra(t, "", "-self-test")
+	if code != 0 || !strings.Contains(stderr, "self-test: ok (3 of 5 proxies passed as expected)") {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+}
+
+func TestSpeedTest(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 256<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("speed file "))
+		// 1MB over about 200ms, about 5000 KB/s
+		for range 4 {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	proxy := startProxy(t, "http")
+
+	opts := testOptions(srv.URL, "speed file")
+	opts.readLimit = 1024 // the rate counts the body past what is kept for matching
+	opts.speedTest = true
+	res := checkProxyHTTP(proxy, opts)
+	if !res.OK || res.SpeedKBps < 2000 || res.SpeedKBps > 6000 {
+		t.Fatalf("ok=%v speed=%d KB/s reason=%s, want about 5000", res.OK, res.SpeedKBps, res.Reason)
+	}
+
+	opts.minSpeed = 1 << 20
+	res = checkProxyHTTP(proxy, opts)
+	if res.OK || !slices.Equal(res.Failed, []string{condSpeed}) || res.Reason != reasonTooSlow {
+		t.Fatalf("under -min-speed: ok=%v failed=%v reason=%s", res.OK, res.Failed, res.Reason)
+	}
+}
+
+func TestSecondsFlag(t *testing.T) {
+	for in, want := range map[string]float64{
+		"5":     5,
+		"1.5":   1.5,
+		"750ms": 0.75,
+		"2s":    2,
+		"1m30s": 90,
+	} {
+		var s secondsFlag
+		if err := s.Set(in); err != nil || float64(s) != want {
+			t.Errorf("Set(%q) = %v, %v, want %v", in, float64(s), err, want)
+		}
+	}
+	for _, bad := range []string{"", "fast", "5 s", "10x"} {
+		var s secondsFlag
+		if err := s.Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+	s := secondsFlag(0.75)
+	if s.String() != "0.75" {
+		t.Errorf("String() = %q", s.String())
+	}
+}
+
+func TestTimeoutUnits(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-done:
+		}
+		w.Write([]byte("late"))
+	}))
+	defer srv.Close()
+	defer close(done)
+	proxy := startProxy(t, "http")
+
+	// 300ms and 0.3 are the same timeout, well short of the 1s response
+	// that the default 5s would wait for
+	for _, timeout := range []string{"300ms", "0.3"} {
+		if stdout, stderr, _ := runProxyra(t, proxy+"\n", "-u", srv.URL, "-r", "late", "-t", timeout); stdout != "" {
+			t.Fatalf("-t %s: printed %q: %s", timeout, stdout, stderr)
+		}
+	}
+	if stdout, stderr, _ := runProxyra(t, proxy+"\n", "-u", srv.URL, "-r", "late", "-t", "3s"); strings.TrimSpace(stdout) != proxy {
+		t.Fatalf("-t 3s: printed %q: %s", stdout, stderr)
+	}
+	if _, stderr, code := runProxyra(t, "", "-t", "soon"); code == 0 || !strings.Contains(stderr, "duration (750ms)") {
+		t.Fatalf("bad -t: exit %d: %s", code, stderr)
+	}
+}
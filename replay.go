@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// loadReplay reads a previous -json run (plain or .gz, with or without the
+// -emit-schema meta line) and returns its proxies in order, with the target
+// each was checked against when the result recorded one
+func loadReplay(path string) ([]string, map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var proxies []string
+	targets := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec struct {
+			Type string `json:"type"`
+			Result
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if rec.Type == "meta" || rec.Proxy == "" {
+			continue
+		}
+		proxies = append(proxies, rec.Proxy)
+		if rec.Target != "" {
+			targets[rec.Proxy] = rec.Target
+		}
+	}
+	return proxies, targets, scanner.Err()
+}
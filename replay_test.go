@@ -0,0 +1,57 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadReplay(t *testing.T) {
+	const run = `{"type":"meta","schema_version":"1"}
+{"proxy":"http://10.0.0.1:8080","ok":true,"target":"http://b.example/"}
+
+{"proxy":"socks5://10.0.0.2:1080","ok":true}
+`
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "run.json")
+	os.WriteFile(plain, []byte(run), 0o644)
+	f, _ := os.Create(filepath.Join(dir, "run.json.gz"))
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(run))
+	zw.Close()
+	f.Close()
+
+	for _, path := range []string{plain, f.Name()} {
+		proxies, targets, err := loadReplay(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !slices.Equal(proxies, []string{"http://10.0.0.1:8080", "socks5://10.0.0.2:1080"}) || len(targets) != 1 || targets["http://10.0.0.1:8080"] != "http://b.example/" {
+			t.Fatalf("%s: proxies %v, targets %v", path, proxies, targets)
+		}
+	}
+
+	os.WriteFile(plain, []byte("{\"proxy\":\"http://10.0.0.1:8080\"}\nnot json\n"), 0o644)
+	if _, _, err := loadReplay(plain); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("bad line: %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	blocked, good := startTextServer(t, "access denied"), startTextServer(t, "welcome")
+	proxy := startProxy(t, "http")
+	path := filepath.Join(t.TempDir(), "run.json")
+
+	// the first run only passes on the fallback, which the result records
+	_, stderr, code := runProxyra(t, proxy+"\n", "-u", blocked, "-fallback-url", good, "-r", "welcome", "-json", "-emit-schema", "-o", path)
+	if code != 0 {
+		t.Fatalf("first run: exit %d: %s", code, stderr)
+	}
+	stdout, stderr, code := runProxyra(t, "", "-replay", path, "-u", blocked, "-r", "welcome")
+	if code != 0 || strings.TrimSpace(stdout) != proxy {
+		t.Fatalf("replay: exit %d stdout=%q stderr=%s", code, stdout, stderr)
+	}
+}
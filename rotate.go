@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is an append-only file that is renamed aside with a timestamp
+// suffix once it reaches maxSize bytes or has been open for interval. Writes
+// are never split, so each result line lands whole in exactly one file.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64         // 0 = no size limit
+	interval time.Duration // 0 = no time limit
+	f        *os.File
+	size     int64
+	opened   time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, interval: interval}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write appends p, rotating first if p would push the file past its limits.
+// An empty file is never rotated, so a line larger than maxSize still fits.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) || (r.interval > 0 && time.Since(r.opened) >= r.interval)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, moves it to path.<timestamp> and reopens path
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	stamp := time.Now().Format("20060102T150405.000")
+	rotated := r.path + "." + stamp
+	// two rotations within a millisecond would collide on the name
+	for i := 1; fileExists(rotated); i++ {
+		rotated = fmt.Sprintf("%s.%s-%d", r.path, stamp, i)
+	}
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseByteSize parses sizes like 512, 64KB, 10MB or 2m (binary multiples)
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
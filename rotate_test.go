@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	r, err := openRotatingFile(path, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{"http://1.1.1.1:80\n", "http://2.2.2.2:80\n", "http://3.3.3.3:80\n"}
+	for _, line := range lines {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != len(lines) {
+		t.Fatalf("got files %v, want one per line", files)
+	}
+	var all []string
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(b), "\n") != 1 {
+			t.Fatalf("%s holds %q, want one whole line", f, b)
+		}
+		all = append(all, string(b))
+	}
+	for _, line := range lines {
+		if !strings.Contains(strings.Join(all, ""), line) {
+			t.Fatalf("line %q was lost", line)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512":    512,
+		"64KB":   64 << 10,
+		"10MB":   10 << 20,
+		"2m":     2 << 20,
+		"1 G":    1 << 30,
+		" 7b ":   7,
+		"0":      0,
+		"100 kb": 100 << 10,
+	} {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1", "1.5MB", "ten"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) accepted", in)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// loadTargets reads one target URL per line from path, skipping blank lines
+// and # comments
+func loadTargets(path string) ([]string, error) {
+	lines, err := readProxiesFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") && !isLocalTarget(line) {
+			return nil, fmt.Errorf("unsupported target %q", line)
+		}
+		targets = append(targets, line)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets in %s", path)
+	}
+	return targets, nil
+}
+
+// assignTargets gives each proxy the next target in turn, in feed order, so
+// every target gets the same share of proxies to within one
+func assignTargets(proxies, targets []string) map[string]string {
+	assigned := make(map[string]string, len(proxies))
+	for i, p := range proxies {
+		assigned[p] = targets[i%len(targets)]
+	}
+	return assigned
+}
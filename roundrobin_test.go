@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTargets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "targets.txt")
+	os.WriteFile(path, []byte("# mirrors\nhttp://a.example/\n\nhttps://b.example/\n"), 0o644)
+	targets, err := loadTargets(path)
+	if err != nil || len(targets) != 2 || targets[1] != "https://b.example/" {
+		t.Fatalf("targets=%v err=%v", targets, err)
+	}
+
+	os.WriteFile(path, []byte("ftp://a.example/\n"), 0o644)
+	if _, err := loadTargets(path); err == nil {
+		t.Error("ftp target accepted")
+	}
+	os.WriteFile(path, []byte("# nothing\n"), 0o644)
+	if _, err := loadTargets(path); err == nil {
+		t.Error("file without targets accepted")
+	}
+}
+
+func TestAssignTargets(t *testing.T) {
+	proxies := []string{"p0", "p1", "p2", "p3", "p4", "p5", "p6"}
+	targets := []string{"a", "b", "c"}
+	assigned := assignTargets(proxies, targets)
+	share := map[string]int{}
+	for i, p := range proxies {
+		if assigned[p] != targets[i%3] {
+			t.Errorf("%s got %s, want %s", p, assigned[p], targets[i%3])
+		}
+		share[assigned[p]]++
+	}
+	if share["a"] != 3 || share["b"] != 2 || share["c"] != 2 {
+		t.Fatalf("shares %v, want within one of each other", share)
+	}
+}
+
+func TestRoundRobinCheck(t *testing.T) {
+	a, b := startTextServer(t, "mirror a"), startTextServer(t, "mirror b")
+	p0, p1 := startProxy(t, "http"), startProxy(t, "socks5")
+	opts := testOptions("", "mirror b")
+	opts.assigned = assignTargets([]string{p0, p1}, []string{a, b})
+	if res := checkProxyHTTP(p0, opts); res.OK {
+		t.Fatal("first proxy was not checked against the first target")
+	}
+	if res := checkProxyHTTP(p1, opts); !res.OK {
+		t.Fatalf("second proxy against the second target: %s", res.Reason)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// sampleLatency re-checks a valid proxy until it has n latency samples,
+// counting the check it already passed as the first, and records their mean
+// and population standard deviation. Failed samples are left out of both.
+func sampleLatency(res *Result, proxyAddr string, n int, opts *checkOptions) {
+	samples := []int64{res.LatencyMs}
+	for i := 1; i < n && !opts.budgetSpent(); i++ {
+		if r := checkProxyHTTP(proxyAddr, opts); r.OK {
+			samples = append(samples, r.LatencyMs)
+		}
+	}
+	res.Samples = len(samples)
+	mean, jitter := meanStddev(samples)
+	res.MeanLatencyMs = int64(math.Round(mean))
+	res.JitterMs = int64(math.Round(jitter))
+}
+
+// meanStddev returns the mean and population standard deviation of xs
+func meanStddev(xs []int64) (float64, float64) {
+	var sum float64
+	for _, x := range xs {
+		sum += float64(x)
+	}
+	mean := sum / float64(len(xs))
+	var sq float64
+	for _, x := range xs {
+		d := float64(x) - mean
+		sq += d * d
+	}
+	return mean, math.Sqrt(sq / float64(len(xs)))
+}
+
+// tooJittery reports whether the sampled jitter exceeds limit (0 = no limit)
+func tooJittery(res *Result, limit time.Duration) bool {
+	return limit > 0 && time.Duration(res.JitterMs)*time.Millisecond > limit
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeanStddev(t *testing.T) {
+	mean, sd := meanStddev([]int64{2, 4, 4, 4, 5, 5, 7, 9})
+	if mean != 5 || sd != 2 {
+		t.Fatalf("mean=%v stddev=%v, want 5 and 2", mean, sd)
+	}
+	if mean, sd := meanStddev([]int64{40}); mean != 40 || sd != 0 {
+		t.Fatalf("one sample: mean=%v stddev=%v", mean, sd)
+	}
+}
+
+func TestSampleLatency(t *testing.T) {
+	target := startTextServer(t, "ok")
+	proxy := startProxy(t, "http")
+	opts := testOptions(target, "ok")
+
+	res := Result{LatencyMs: 1000}
+	sampleLatency(&res, proxy, 4, opts)
+	if res.Samples != 4 {
+		t.Fatalf("samples = %d, want 4", res.Samples)
+	}
+	// the slow first sample pulls the mean up and dominates the jitter
+	if res.MeanLatencyMs < 250 || math.Abs(float64(res.JitterMs)-433) > 10 {
+		t.Fatalf("mean=%d jitter=%d", res.MeanLatencyMs, res.JitterMs)
+	}
+	if !tooJittery(&res, 100*time.Millisecond) || tooJittery(&res, time.Second) || tooJittery(&res, 0) {
+		t.Fatalf("tooJittery wrong for jitter %dms", res.JitterMs)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "sync"
+
+// costScheduler hands out proxies grouped by scheme so that each scheme gets
+// about the same share of worker time, rather than the same number of jobs.
+// Every scheme carries a virtual clock that advances by its estimated check
+// cost on each dispatch, and the next job comes from the scheme whose clock
+// is furthest behind. Cheap schemes are therefore dispatched proportionally
+// more often, and a slow scheme that times out a lot cannot hog the workers.
+type costScheduler struct {
+	mu      sync.Mutex
+	order   []string // schemes in first-seen order, for deterministic ties
+	queues  map[string][]string
+	clock   map[string]float64
+	cost    map[string]float64 // moving average check cost in ms
+	timeout float64            // ms, the cost of a check that timed out
+}
+
+func newCostScheduler(proxies []string, timeout float64) *costScheduler {
+	s := &costScheduler{
+		queues:  make(map[string][]string),
+		clock:   make(map[string]float64),
+		cost:    make(map[string]float64),
+		timeout: timeout * 1000,
+	}
+	for _, p := range proxies {
+		scheme := proxyScheme(p)
+		if _, ok := s.queues[scheme]; !ok {
+			s.order = append(s.order, scheme)
+			// until there are samples, assume a check takes half the timeout
+			s.cost[scheme] = s.timeout / 2
+		}
+		s.queues[scheme] = append(s.queues[scheme], p)
+	}
+	return s
+}
+
+// next returns the next proxy to check, or false when all were handed out
+func (s *costScheduler) next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pick := ""
+	for _, scheme := range s.order {
+		if len(s.queues[scheme]) > 0 && (pick == "" || s.clock[scheme] < s.clock[pick]) {
+			pick = scheme
+		}
+	}
+	if pick == "" {
+		return "", false
+	}
+	p := s.queues[pick][0]
+	s.queues[pick] = s.queues[pick][1:]
+	s.clock[pick] += s.cost[pick]
+	return p, true
+}
+
+// observe folds a finished check into its scheme's cost estimate. Failed
+// checks carry no latency, so a timeout counts as the full timeout and any
+// other failure as a tenth of it.
+func (s *costScheduler) observe(res Result) {
+	ms := float64(res.LatencyMs)
+	switch {
+	case res.Reason == reasonTimeout:
+		ms = s.timeout
+	case !res.OK && ms == 0:
+		ms = s.timeout / 10
+	}
+	s.mu.Lock()
+	if c, ok := s.cost[res.Scheme]; ok {
+		s.cost[res.Scheme] = 0.8*c + 0.2*ms
+	}
+	s.mu.Unlock()
+}
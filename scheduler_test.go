@@ -0,0 +1,154 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func schedulerInput(n int) []string {
+	proxies := make([]string, 0, 2*n)
+	for i := range n {
+		proxies = append(proxies, "http://10.0.0."+strconv.Itoa(i%250)+":"+strconv.Itoa(8000+i))
+		proxies = append(proxies, "socks5://10.0.1."+strconv.Itoa(i%250)+":"+strconv.Itoa(1000+i))
+	}
+	return proxies
+}
+
+func TestCostScheduler(t *testing.T) {
+	s := newCostScheduler(schedulerInput(100), 10)
+	counts := map[string]int{}
+	for range 100 {
+		p, ok := s.next()
+		if !ok {
+			t.Fatal("ran dry early")
+		}
+		scheme := proxyScheme(p)
+		counts[scheme]++
+		// http checks take 100ms, socks5 ones time out
+		if scheme == "http" {
+			s.observe(Result{Scheme: scheme, OK: true, LatencyMs: 100})
+		} else {
+			s.observe(Result{Scheme: scheme, Reason: reasonTimeout})
+		}
+	}
+	if counts["http"] < 5*counts["socks5"] {
+		t.Fatalf("dispatched %v, want the cheap scheme far more often", counts)
+	}
+
+	n := 100
+	for _, ok := s.next(); ok; _, ok = s.next() {
+		n++
+	}
+	if n != 200 {
+		t.Fatalf("handed out %d proxies, want all 200", n)
+	}
+}
+
+// simCheck is the simulated outcome of checking p: http proxies answer in
+// 200ms, socks4 ones in 1.5s and socks5 ones hit the 10s timeout
+func simCheck(p string) (Result, int64) {
+	switch scheme := proxyScheme(p); scheme {
+	case "http":
+		return Result{Scheme: scheme, OK: true, LatencyMs: 200}, 200
+	case "socks4":
+		return Result{Scheme: scheme, OK: true, LatencyMs: 1500}, 1500
+	default:
+		return Result{Scheme: scheme, Reason: reasonTimeout}, 10000
+	}
+}
+
+// simulate runs a check list through workers on a virtual clock, handing each
+// free worker the next proxy and observing results in finishing order. It
+// returns the makespan and when each scheme's last check finished, in ms.
+func simulate(next func() (string, bool), observe func(Result), workers int) (int64, map[string]int64) {
+	type job struct {
+		end int64
+		res Result
+	}
+	var running []job
+	start := func(now int64) {
+		if p, ok := next(); ok {
+			res, ms := simCheck(p)
+			running = append(running, job{now + ms, res})
+		}
+	}
+	for range workers {
+		start(0)
+	}
+	var now int64
+	done := map[string]int64{}
+	for len(running) > 0 {
+		first := 0
+		for i, j := range running {
+			if j.end < running[first].end {
+				first = i
+			}
+		}
+		j := running[first]
+		running = slices.Delete(running, first, first+1)
+		now = j.end
+		done[j.res.Scheme] = now
+		observe(j.res)
+		start(now)
+	}
+	return now, done
+}
+
+func makespanInput() []string {
+	proxies := schedulerInput(300)
+	for i := range 300 {
+		proxies = append(proxies, "socks4://10.0.2."+strconv.Itoa(i%250)+":"+strconv.Itoa(4000+i))
+	}
+	return proxies
+}
+
+// fifoMakespan and costMakespan simulate the same list dispatched in input
+// order and through the cost scheduler
+func fifoMakespan(proxies []string, workers int) (int64, map[string]int64) {
+	queue := slices.Clone(proxies)
+	next := func() (string, bool) {
+		if len(queue) == 0 {
+			return "", false
+		}
+		p := queue[0]
+		queue = queue[1:]
+		return p, true
+	}
+	return simulate(next, func(Result) {}, workers)
+}
+
+func costMakespan(proxies []string, workers int) (int64, map[string]int64) {
+	s := newCostScheduler(proxies, 10)
+	return simulate(s.next, s.observe, workers)
+}
+
+func TestSchedulerMakespan(t *testing.T) {
+	proxies := makespanInput()
+	for _, workers := range []int{10, 50, 100} {
+		fifo, fifoDone := fifoMakespan(proxies, workers)
+		cost, costDone := costMakespan(proxies, workers)
+		t.Logf("%d workers: makespan fifo %dms, cost %dms; http done at %dms vs %dms",
+			workers, fifo, cost, fifoDone["http"], costDone["http"])
+		// the total work is the same, so holding the timeouts back can only
+		// stretch the tail, by at most one check
+		if cost > fifo+10000 {
+			t.Errorf("%d workers: cost scheduler makespan %dms, more than a timeout over fifo's %dms", workers, cost, fifo)
+		}
+		// what it buys is the cheap scheme no longer queueing behind timeouts
+		if costDone["http"]*3 > fifoDone["http"] {
+			t.Errorf("%d workers: http done at %dms, fifo %dms; want well ahead", workers, costDone["http"], fifoDone["http"])
+		}
+	}
+}
+
+func BenchmarkSchedulerMakespan(b *testing.B) {
+	proxies := makespanInput()
+	var fifo, cost int64
+	for b.Loop() {
+		fifo, _ = fifoMakespan(proxies, 50)
+		cost, _ = costMakespan(proxies, 50)
+	}
+	b.ReportMetric(float64(fifo), "fifo-ms")
+	b.ReportMetric(float64(cost), "cost-ms")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runSelfTest checks this binary end to end against loopback fixtures: a
+// target server that gzips its page, working stub proxies of every stubbed scheme, a proxy that
+// answers with the wrong page and a dead address. It runs the binary itself
+// on them, as a user would, and returns the exit status: 0 when exactly the
+// working proxies were reported.
+func runSelfTest() int {
+	marker := "proxyra-self-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	target, stopTarget, err := serveText(marker)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "self-test: starting target:", err)
+		return 1
+	}
+	defer stopTarget()
+
+	var want, proxies []string
+	for _, scheme := range []string{"http", "socks4", "socks5"} {
+		addr, stop, err := startStubProxy(scheme)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "self-test: starting stub proxy:", err)
+			return 1
+		}
+		defer stop()
+		want = append(want, addr)
+		proxies = append(proxies, addr)
+	}
+
+	// a "proxy" that answers every request itself, with the wrong page
+	wrong, stopWrong, err := serveText("not the target")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "self-test: starting stub proxy:", err)
+		return 1
+	}
+	defer stopWrong()
+	proxies = append(proxies, strings.TrimSuffix(wrong, "/"))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "self-test:", err)
+		return 1
+	}
+	dead := "socks5://" + ln.Addr().String()
+	ln.Close()
+	proxies = append(proxies, dead)
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "self-test:", err)
+		return 1
+	}
+	// asking for gzip ourselves stops net/http decompressing it, so the marker
+	// only matches if the checker decodes the body
+	cmd := exec.Command(exe, "-u", target, "-r", marker, "-H", "Accept-Encoding: gzip", "-t", "3", "-c", strconv.Itoa(len(proxies)), "-quiet-errors-only")
+	cmd.Stdin = strings.NewReader(strings.Join(proxies, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "self-test: run failed:", err)
+		return 1
+	}
+
+	got := strings.Fields(string(out))
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		fmt.Fprintf(os.Stderr, "self-test: FAIL\n  want: %s\n  got:  %s\n", strings.Join(want, " "), strings.Join(got, " "))
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "self-test: ok (%d of %d proxies passed as expected)\n", len(got), len(proxies))
+	return 0
+}
+
+// serveText runs a loopback HTTP server answering every request with text,
+// including absolute-form proxy requests, gzipped for clients that accept it
+func serveText(text string) (string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	// repeated so that gzip really compresses the page instead of storing it verbatim
+	body := bytes.Repeat([]byte(text+"\n"), 16)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write(body)
+	_ = gz.Close()
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipped.Bytes())
+			return
+		}
+		_, _ = w.Write(body)
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	return "http://" + ln.Addr().String() + "/", func() { srv.Close() }, nil
+}
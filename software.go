@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// softwareRe finds a known proxy product, with its version when given, in
+// Server, Via or X-Cache header values
+var softwareRe = regexp.MustCompile(`(?i)\b(squid|tinyproxy|privoxy|varnish|haproxy|mitmproxy|3proxy|polipo|ccproxy|wingate|ziproxy|apache traffic server|ats|nginx|apache|envoy|bluecoat|cloudflare)(?:[/ ]v?([0-9][0-9A-Za-z.\-]*))?`)
+
+// detectSoftware asks an HTTP proxy for a name that cannot resolve, so the
+// error page comes from the proxy itself rather than a target, and guesses
+// its software from the response headers. It returns "" for non-HTTP
+// proxies or when nothing identifying was sent.
+func detectSoftware(proxyAddr string, opts *checkOptions) string {
+	u, err := url.Parse(proxyAddr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	d := time.Duration(opts.timeout * float64(time.Second))
+	ctx, cancel := context.WithTimeout(opts.ctx, d)
+	defer cancel()
+	conn, err := dialProxy(ctx, "tcp", u.Host)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(d))
+
+	_, err = io.WriteString(conn, "GET http://proxyra-probe.invalid/ HTTP/1.1\r\nHost: proxyra-probe.invalid\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		return ""
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	return guessSoftware(resp.Header)
+}
+
+// guessSoftware names the proxy product from response headers, preferring
+// Via and X-Cache, which proxies add, over Server, which may be the origin's.
+// An unrecognised Server header is returned as is.
+func guessSoftware(h http.Header) string {
+	for _, key := range []string{"Via", "X-Cache", "X-Cache-Lookup", "Server"} {
+		for _, v := range h.Values(key) {
+			// Varnish sends "1.1 varnish (Varnish/7.4)", so a later match
+			// that carries a version wins over an earlier bare name
+			matches := softwareRe.FindAllStringSubmatch(v, -1)
+			if len(matches) == 0 {
+				continue
+			}
+			m := matches[0]
+			if i := slices.IndexFunc(matches, func(m []string) bool { return m[2] != "" }); i >= 0 {
+				m = matches[i]
+			}
+			name := strings.ToLower(m[1])
+			if m[2] != "" {
+				return name + "/" + m[2]
+			}
+			return name
+		}
+	}
+	if h.Get("X-Squid-Error") != "" {
+		return "squid"
+	}
+	return h.Get("Server")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestGuessSoftware(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Server": {"squid/5.7"}}, "squid/5.7"},
+		{http.Header{"Via": {"1.1 gateway (tinyproxy/1.11.1)"}}, "tinyproxy/1.11.1"},
+		// Via is the proxy's own, Server may be the origin's
+		{http.Header{"Server": {"nginx/1.25.3"}, "Via": {"1.1 varnish (Varnish/7.4)"}}, "varnish/7.4"},
+		{http.Header{"X-Squid-Error": {"ERR_DNS_FAIL 0"}}, "squid"},
+		{http.Header{"Server": {"MyProxy 2"}}, "MyProxy 2"},
+		{http.Header{}, ""},
+	}
+	for _, tt := range tests {
+		if got := guessSoftware(tt.header); got != tt.want {
+			t.Errorf("guessSoftware(%v) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDetectSoftware(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, "HTTP/1.1 503 Service Unavailable\r\nServer: squid/6.6\r\nX-Squid-Error: ERR_DNS_FAIL 0\r\nContent-Length: 0\r\n\r\n")
+			conn.Close()
+		}
+	}()
+	opts := testOptions("", ".")
+	if got := detectSoftware("http://"+ln.Addr().String(), opts); got != "squid/6.6" {
+		t.Fatalf("detected %q, want squid/6.6", got)
+	}
+	if got := detectSoftware("socks5://"+ln.Addr().String(), opts); got != "" {
+		t.Fatalf("socks5 proxy detected as %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// resultBuffer holds results until the run ends. Once their encoded size
+// would pass limit it moves them to a temp file and keeps only latencies in
+// memory, so features that need every result stay bounded on huge lists.
+type resultBuffer struct {
+	limit      int64 // soft cap in bytes, 0 = unlimited
+	normalized bool  // rank on NormLatencyMs, with -normalize-latency
+	mem        []Result
+	memBytes   int64
+	latencies  []int64 // what results are ranked on, in insertion order
+	file       *os.File
+	w          *bufio.Writer
+	offsets    []int64 // start of each result's line in file
+	fileBytes  int64
+	logf       func(string, ...any)
+}
+
+func newResultBuffer(limit int64, normalized bool, logf func(string, ...any)) *resultBuffer {
+	return &resultBuffer{limit: limit, normalized: normalized, logf: logf}
+}
+
+func (b *resultBuffer) add(res Result) error {
+	latency := res.LatencyMs
+	if b.normalized {
+		latency = res.NormLatencyMs
+	}
+	b.latencies = append(b.latencies, latency)
+	line, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if b.file == nil && b.limit > 0 && b.memBytes+int64(len(line)) > b.limit {
+		if err := b.spill(); err != nil {
+			return err
+		}
+	}
+	if b.file != nil {
+		return b.write(line)
+	}
+	b.mem = append(b.mem, res)
+	b.memBytes += int64(len(line))
+	return nil
+}
+
+// spill moves the in-memory results to a temp file
+func (b *resultBuffer) spill() error {
+	f, err := os.CreateTemp("", "proxyra-buffer-*.ndjson")
+	if err != nil {
+		return fmt.Errorf("spilling buffered results: %w", err)
+	}
+	b.file, b.w = f, bufio.NewWriter(f)
+	b.logf("Warning: buffered results passed -max-memory, spilling to %s\n", f.Name())
+	for _, res := range b.mem {
+		line, _ := json.Marshal(res)
+		if err := b.write(line); err != nil {
+			return err
+		}
+	}
+	b.mem, b.memBytes = nil, 0
+	return nil
+}
+
+// write appends one encoded result to the spill file
+func (b *resultBuffer) write(line []byte) error {
+	b.offsets = append(b.offsets, b.fileBytes)
+	n, err := b.w.Write(append(line, '\n'))
+	b.fileBytes += int64(n)
+	return err
+}
+
+// each calls fn with every buffered result and its index, in insertion order
+func (b *resultBuffer) each(fn func(int, Result)) error {
+	if b.file == nil {
+		for i, res := range b.mem {
+			fn(i, res)
+		}
+		return nil
+	}
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(0, 0); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(b.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for i := 0; scanner.Scan(); i++ {
+		var res Result
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return err
+		}
+		fn(i, res)
+	}
+	return scanner.Err()
+}
+
+// inOrder calls fn with the buffered results at the given indices, in that
+// order. Spilled results are read back one line at a time.
+func (b *resultBuffer) inOrder(order []int, fn func(Result)) error {
+	if b.file == nil {
+		for _, i := range order {
+			fn(b.mem[i])
+		}
+		return nil
+	}
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	for _, i := range order {
+		end := b.fileBytes
+		if i+1 < len(b.offsets) {
+			end = b.offsets[i+1]
+		}
+		line := make([]byte, end-b.offsets[i])
+		if _, err := b.file.ReadAt(line, b.offsets[i]); err != nil {
+			return err
+		}
+		var res Result
+		if err := json.Unmarshal(line, &res); err != nil {
+			return err
+		}
+		fn(res)
+	}
+	return nil
+}
+
+// Close removes the spill file, if any
+func (b *resultBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestNormalizedRanking(t *testing.T) {
+	// SOCKS5 pays more per-request overhead than HTTP, so its raw latency
+	// hides that this proxy is the faster one
+	baselines := map[string]int64{"http": 10, "socks5": 80}
+	results := []Result{
+		{Proxy: "http://10.0.0.1:8080", Scheme: "http", LatencyMs: 60},
+		{Proxy: "socks5://10.0.0.2:1080", Scheme: "socks5", LatencyMs: 90},
+		{Proxy: "http://10.0.0.3:8080", Scheme: "http", LatencyMs: 120},
+	}
+	ranked := func(normalized bool) ([]int, []bool) {
+		b := newResultBuffer(0, normalized, t.Logf)
+		defer b.Close()
+		for _, res := range results {
+			normalizeLatency(&res, baselines)
+			if err := b.add(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return latencyOrder(b.latencies), fastestMask(b.latencies, 33)
+	}
+
+	order, keep := ranked(false)
+	if !slices.Equal(order, []int{0, 1, 2}) || !slices.Equal(keep, []bool{true, false, false}) {
+		t.Fatalf("raw ranking = %v, fastest = %v", order, keep)
+	}
+	order, keep = ranked(true)
+	if !slices.Equal(order, []int{1, 0, 2}) || !slices.Equal(keep, []bool{false, true, false}) {
+		t.Fatalf("normalized ranking = %v, fastest = %v", order, keep)
+	}
+}
+
+func TestResultBufferSpill(t *testing.T) {
+	var results []Result
+	for i := range 20 {
+		results = append(results, Result{Proxy: "http://10.0.0." + strconv.Itoa(i) + ":8080", OK: true, LatencyMs: int64(100 - i)})
+	}
+	spilled := false
+	b := newResultBuffer(256, false, func(format string, a ...any) { spilled = true })
+	for _, res := range results {
+		if err := b.add(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !spilled || b.file == nil || len(b.mem) != 0 {
+		t.Fatalf("buffer did not spill past its limit: warned=%v mem=%d", spilled, len(b.mem))
+	}
+	path := b.file.Name()
+
+	var got []string
+	if err := b.each(func(i int, res Result) { got = append(got, res.Proxy) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(results) || got[0] != results[0].Proxy || got[19] != results[19].Proxy {
+		t.Fatalf("each read back %v", got)
+	}
+	// latencies stay in memory, so ranking reads spilled results out of order
+	got = got[:0]
+	if err := b.inOrder(latencyOrder(b.latencies)[:3], func(res Result) { got = append(got, res.Proxy) }); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{results[19].Proxy, results[18].Proxy, results[17].Proxy}) {
+		t.Fatalf("fastest three = %v", got)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("spill file %s left behind: %v", path, err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+	"github.com/ogpourya/proxyra/xray"
+)
+
+// proxyFilter takes the proxies one at a time, so a streamed list is filtered
+// as it arrives, through the input steps in order: -input-format, IPv6
+// normalization, the -via xray check, -proxy-auth, credential normalization,
+// dedupe, -no-private, -sample-rate and the checkpoint. It counts what it
+// drops for report.
+type proxyFilter struct {
+	format       string               // -input-format
+	warnf        func(string, ...any) // reports lines not in format
+	auth         *url.Userinfo        // for proxies without credentials, nil unless -proxy-auth
+	noXray       bool                 // drop xray links, whose outbounds -via cannot chain
+	normalize    bool
+	noPrivate    bool
+	sampleRate   float64
+	rng          *rand.Rand
+	cp           *checkpoint // nil unless -checkpoint
+	recheckAfter time.Duration
+	seen         map[string]struct{}
+
+	unique, private, sampledOut, resumed, xray int
+}
+
+// admit returns the proxy as it should be checked, or false to drop it
+func (f *proxyFilter) admit(p string) (string, bool) {
+	parsed, ok := parseInputLine(p, f.format)
+	if !ok {
+		f.warnf("Warning: skipping %q, not in -input-format %s\n", p, f.format)
+		return "", false
+	}
+	// converted lines have their credentials encoded already
+	converted := parsed != p
+	p = parsed
+	if !isXrayLink(p) {
+		p = proxyra.CanonicalProxy(p)
+	} else if f.noXray {
+		f.xray++
+		return "", false
+	}
+	if f.auth != nil && !isXrayLink(p) {
+		p = withCredentials(p, f.auth)
+	}
+	if f.normalize && !converted && !isXrayLink(p) {
+		p = normalizeCredentials(p)
+	}
+	if _, dup := f.seen[p]; dup {
+		return "", false
+	}
+	f.seen[p] = struct{}{}
+	f.unique++
+	if f.noPrivate && !isXrayLink(p) && isPrivateHost(proxyHost(p)) {
+		f.private++
+		return "", false
+	}
+	if f.sampleRate < 1 && f.rng.Float64() >= f.sampleRate {
+		f.sampledOut++
+		return "", false
+	}
+	if f.cp != nil && f.cp.fresh(p, f.recheckAfter) {
+		f.resumed++
+		return "", false
+	}
+	return p, true
+}
+
+// sampleRand is the -sample-rate source: the filter's own, so the sample a
+// seed picks does not depend on what else draws from the run's rng. A zero
+// seed samples at random.
+func sampleRand(seed uint64) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// withCredentials gives proxyAddr the credentials ui unless it has its own
+func withCredentials(proxyAddr string, ui *url.Userinfo) string {
+	scheme, rest := "", proxyAddr
+	if i := strings.Index(proxyAddr, "://"); i >= 0 {
+		scheme, rest = proxyAddr[:i+3], proxyAddr[i+3:]
+	}
+	if strings.Contains(rest, "@") {
+		return proxyAddr
+	}
+	return scheme + ui.String() + "@" + rest
+}
+
+// report prints what the filter dropped, once the input has been read
+func (f *proxyFilter) report(infof func(string, ...any)) {
+	if f.xray > 0 {
+		infof("Skipped %d xray links: their outbounds connect on their own and cannot be chained with -via\n", f.xray)
+	}
+	if f.private > 0 {
+		infof("Skipped %d proxies with private or reserved addresses\n", f.private)
+	}
+	if f.sampleRate < 1 {
+		kept := f.unique - f.private
+		infof("Sampled %d of %d proxies\n", kept-f.sampledOut, kept)
+	}
+	if f.resumed > 0 {
+		infof("Resuming: skipped %d proxies already in checkpoint\n", f.resumed)
+	}
+}
+
+// stdinIsPipe reports whether proxies can be read from stdin
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == 0
+}
+
+// streamLines sends the non-empty lines of r, trimmed, until r ends or
+// either stop channel closes, then closes lines. A read error is sent on
+// errc, which has room for it.
+func streamLines(r io.Reader, lines chan<- string, errc chan<- error, stop1, stop2 <-chan struct{}) {
+	defer close(lines)
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, maxLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		select {
+		case lines <- line:
+		case <-stop1:
+			return
+		case <-stop2:
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errc <- err
+	}
+}
+
+// startXray replaces the xray links in proxies (vless://, vmess://, etc.)
+// with the local SOCKS5 addresses of one xray instance serving them all,
+// recording each address's link in proxyMap. Links that cannot be parsed
+// are reported and left as they are. It returns nil when there were no
+// links.
+func startXray(proxies []string, proxyMap *sync.Map) (*xray.Manager, error) {
+	var mgr *xray.Manager
+	for i, p := range proxies {
+		if !isXrayLink(p) {
+			continue
+		}
+		if mgr == nil {
+			mgr = xray.NewManager()
+		}
+		ob, err := xray.ParseLink(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing xray link: %v\n", err)
+			continue
+		}
+		inst, err := mgr.AddOutbound(ob)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error adding xray outbound: %v\n", err)
+			continue
+		}
+		localAddr := fmt.Sprintf("socks5://127.0.0.1:%d", inst.Port)
+		proxyMap.Store(localAddr, p)
+		proxies[i] = localAddr
+	}
+	if mgr == nil {
+		return nil, nil
+	}
+	if err := mgr.Start(); err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
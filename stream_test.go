@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func sample(seed uint64, rate float64, n int) []string {
+	f := &proxyFilter{
+		format:     inputAuto,
+		warnf:      func(string, ...any) {},
+		sampleRate: rate,
+		rng:        sampleRand(seed),
+		seen:       make(map[string]struct{}),
+	}
+	var kept []string
+	for i := range n {
+		if p, ok := f.admit(fmt.Sprintf("http://10.0.%d.%d:8080", i/256, i%256)); ok {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func TestSampleRateSeeded(t *testing.T) {
+	const n = 10000
+	a := sample(42, 0.1, n)
+	if len(a) < n/10-300 || len(a) > n/10+300 {
+		t.Fatalf("sampled %d of %d at 0.1", len(a), n)
+	}
+	if b := sample(42, 0.1, n); !slices.Equal(a, b) {
+		t.Fatal("same seed sampled different proxies")
+	}
+	if c := sample(43, 0.1, n); slices.Equal(a, c) {
+		t.Fatal("different seeds sampled the same proxies")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// startStubProxy runs a minimal in-process proxy of the given scheme on
+// loopback. It returns the proxy URL and a function that stops it.
+func startStubProxy(scheme string) (string, func(), error) {
+	var handle func(net.Conn)
+	switch scheme {
+	case "http":
+		handle = serveStubHTTP
+	case "socks5":
+		handle = serveStubSOCKS5
+	case "socks4", "socks4a":
+		handle = serveStubSOCKS4
+	default:
+		return "", nil, fmt.Errorf("no stub for scheme %s", scheme)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return scheme + "://" + ln.Addr().String(), func() { ln.Close() }, nil
+}
+
+// splice copies in both directions until either side closes
+func splice(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	b.Close()
+}
+
+func serveStubHTTP(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if req.Method == http.MethodConnect {
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			conn.Close()
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		splice(conn, upstream)
+		return
+	}
+
+	defer conn.Close()
+	req.RequestURI = ""
+	resp, err := (&http.Transport{DisableKeepAlives: true}).RoundTrip(req)
+	if err != nil {
+		_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer resp.Body.Close()
+	_ = resp.Write(conn)
+}
+
+func serveStubSOCKS5(conn net.Conn) {
+	buf := make([]byte, 256)
+	// greeting: version, method count, methods; only "no auth" is offered back
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		conn.Close()
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+		conn.Close()
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		_, err := io.ReadFull(conn, buf[:4])
+		if err != nil {
+			conn.Close()
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			conn.Close()
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			conn.Close()
+			return
+		}
+		host = string(buf[:n])
+	case 4:
+		if _, err := io.ReadFull(conn, buf[:16]); err != nil {
+			conn.Close()
+			return
+		}
+		host = net.IP(buf[:16]).String()
+	default:
+		conn.Close()
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		conn.Close()
+		return
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	splice(conn, upstream)
+}
+
+func serveStubSOCKS4(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(br, head); err != nil || head[0] != 4 || head[1] != 1 {
+		conn.Close()
+		return
+	}
+	port := binary.BigEndian.Uint16(head[2:4])
+	host := net.IP(head[4:8]).String()
+	if _, err := br.ReadString(0); err != nil { // user id
+		conn.Close()
+		return
+	}
+	// 0.0.0.x with x != 0 marks a SOCKS4a request carrying a hostname
+	if head[4] == 0 && head[5] == 0 && head[6] == 0 && head[7] != 0 {
+		name, err := br.ReadString(0)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		host = name[:len(name)-1]
+	}
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_, _ = conn.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	_, _ = conn.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+	splice(conn, upstream)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// suiteRow is one target of a -suite file
+type suiteRow struct {
+	url      string
+	re       *regexp.Regexp
+	required bool
+}
+
+// suiteOutcome is a proxy's result against one suite row
+type suiteOutcome struct {
+	URL       string `json:"url"`
+	OK        bool   `json:"ok"`
+	Required  bool   `json:"required"`
+	Reason    string `json:"reason,omitempty"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+}
+
+// loadSuite reads a -suite file. Each non-empty line that is not a # comment
+// holds a URL, a regex and optionally "required" (the default) or "optional",
+// separated by whitespace; regexes needing spaces can use \s.
+func loadSuite(path string) ([]suiteRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []suiteRow
+	hasRequired := false
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want URL REGEX [required|optional]", lineNo)
+		}
+		if !strings.HasPrefix(fields[0], "http://") && !strings.HasPrefix(fields[0], "https://") && !isLocalTarget(fields[0]) {
+			return nil, fmt.Errorf("line %d: unsupported URL %q", lineNo, fields[0])
+		}
+		re, err := regexp.Compile(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		row := suiteRow{url: fields[0], re: re, required: true}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "required":
+			case "optional":
+				row.required = false
+			default:
+				return nil, fmt.Errorf("line %d: expected required or optional, got %q", lineNo, fields[2])
+			}
+		}
+		hasRequired = hasRequired || row.required
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !hasRequired {
+		return nil, fmt.Errorf("no required rows")
+	}
+	return rows, nil
+}
+
+// checkSuite checks a proxy against every suite row. It passes when all
+// required rows pass; the reported latency is that of the slowest row.
+// The -urls policies stop early instead: "all" at the first failure and
+// "any" at the first pass.
+func checkSuite(proxyAddr string, opts *checkOptions) Result {
+	res := Result{Proxy: proxyAddr, OK: true}
+	for _, row := range opts.suite {
+		r := performHTTPCheck(proxyAddr, row.url, row.re, opts)
+		res.Suite = append(res.Suite, suiteOutcome{URL: row.url, OK: r.OK, Required: row.required, Reason: r.Reason, LatencyMs: r.LatencyMs})
+		res.LatencyMs = max(res.LatencyMs, r.LatencyMs)
+		if r.OK && opts.matchPolicy == matchAny {
+			res.OK, res.Reason, res.Failed = true, "", nil
+			return res
+		}
+		if row.required && !r.OK && res.OK {
+			res.OK = false
+			res.Reason = r.Reason
+			res.Failed = r.Failed
+		}
+		if !res.OK && opts.matchPolicy == matchAll {
+			break
+		}
+	}
+	return res
+}
+
+// policies for checking a proxy against the -urls targets
+const (
+	matchAll = "all" // every target must match
+	matchAny = "any" // one matching target is enough
+)
+
+// urlRows turns the -urls targets into suite rows sharing the -r regex
+func urlRows(urls []string, re *regexp.Regexp) []suiteRow {
+	rows := make([]suiteRow, len(urls))
+	for i, u := range urls {
+		rows[i] = suiteRow{url: u, re: re, required: true}
+	}
+	return rows
+}
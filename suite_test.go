@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSuite(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "suite.txt")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSuite(t *testing.T) {
+	rows, err := loadSuite(writeSuite(t, "# targets\nhttp://a.example/ ok\n\nhttps://b.example/ \\d+ optional\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].url != "http://a.example/" || !rows[0].required || rows[1].required || !rows[1].re.MatchString("42") {
+		t.Fatalf("rows = %+v", rows)
+	}
+
+	for text, want := range map[string]string{
+		"http://a.example/\n":                 "line 1",
+		"ftp://a.example/ ok\n":               "unsupported URL",
+		"http://a.example/ ( \n":              "line 1",
+		"http://a.example/ ok maybe\n":        "required or optional",
+		"http://a.example/ ok optional\n":     "no required rows",
+		"# only a comment\n":                  "no required rows",
+		"http://a.example/ ok\nhttp://b ok x": "line 2",
+	} {
+		if _, err := loadSuite(writeSuite(t, text)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", text, err, want)
+		}
+	}
+}
+
+func TestCheckSuite(t *testing.T) {
+	good := startTextServer(t, "welcome")
+	blocked := startTextServer(t, "access denied")
+	proxy := startProxy(t, "http")
+
+	rows, err := loadSuite(writeSuite(t, good+" welcome\n"+blocked+" welcome optional\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions("", ".")
+	opts.suite = rows
+	res := checkSuite(proxy, opts)
+	if !res.OK || len(res.Suite) != 2 || !res.Suite[0].OK || res.Suite[1].OK {
+		t.Fatalf("optional failure: ok=%v suite=%+v", res.OK, res.Suite)
+	}
+
+	rows[1].required = true
+	res = checkSuite(proxy, opts)
+	if res.OK || res.Reason != reasonNoMatch || len(res.Suite) != 2 {
+		t.Fatalf("required failure: ok=%v reason=%s suite=%+v", res.OK, res.Reason, res.Suite)
+	}
+
+	// -urls policies stop early
+	opts.suite = urlRows([]string{blocked, good}, rows[0].re)
+	opts.matchPolicy = matchAll
+	if res := checkSuite(proxy, opts); res.OK || len(res.Suite) != 1 {
+		t.Fatalf("all: ok=%v suite=%+v", res.OK, res.Suite)
+	}
+	opts.suite = urlRows([]string{good, blocked}, rows[0].re)
+	opts.matchPolicy = matchAny
+	if res := checkSuite(proxy, opts); !res.OK || len(res.Suite) != 1 {
+		t.Fatalf("any: ok=%v suite=%+v", res.OK, res.Suite)
+	}
+}
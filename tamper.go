@@ -0,0 +1,18 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// bodyDigest is the SHA-256 of body after every ignore match is removed, so
+// timestamps, nonces and other volatile sections do not count as tampering.
+// Only the first -read-limit bytes of a body are ever compared.
+func bodyDigest(body []byte, ignore *regexp.Regexp) string {
+	if ignore != nil {
+		body = ignore.ReplaceAll(body, nil)
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// startTextServer runs serveText for the length of the test
+func startTextServer(t *testing.T, text string) string {
+	t.Helper()
+	u, stop, err := serveText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	return u
+}
+
+func TestVerifyHash(t *testing.T) {
+	target := startTextServer(t, "<p>original page</p>")
+	// serveText answers proxy requests too, so this proxy replaces every page
+	tampering := strings.TrimSuffix(startTextServer(t, "<p>original page</p><script>ad()</script>"), "/")
+	honest := startProxy(t, "http")
+
+	opts := testOptions(target, "original")
+	_, body, err := fetchBody("", target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.bodyHash = bodyDigest(body, nil)
+
+	if res := checkProxyHTTP(honest, opts); !res.OK || res.Modified {
+		t.Fatalf("honest proxy: ok=%v modified=%v reason=%s", res.OK, res.Modified, res.Reason)
+	}
+	res := checkProxyHTTP(tampering, opts)
+	if res.OK || res.Reason != reasonTampered || !res.Modified {
+		t.Fatalf("tampering proxy: ok=%v modified=%v reason=%s", res.OK, res.Modified, res.Reason)
+	}
+
+	opts.keepModified = true
+	res = checkProxyHTTP(tampering, opts)
+	if !res.OK || !res.Modified {
+		t.Fatalf("tampering proxy with -flag-modified: ok=%v modified=%v reason=%s", res.OK, res.Modified, res.Reason)
+	}
+	if line := verboseLine(res); !strings.Contains(line, " OK modified\t") {
+		t.Fatalf("verbose line %q", line)
+	}
+}
+
+func TestBodyDigestIgnore(t *testing.T) {
+	a := []byte("<p>hello</p><!-- t=1700000000 -->")
+	b := []byte("<p>hello</p><!-- t=1700000099 -->")
+	if bodyDigest(a, nil) == bodyDigest(b, nil) {
+		t.Fatal("different bodies hashed the same")
+	}
+	ignore := regexp.MustCompile(`<!-- t=\d+ -->`)
+	if bodyDigest(a, ignore) != bodyDigest(b, ignore) {
+		t.Fatal("masked sections still change the hash")
+	}
+}
+
+func TestVerifyHashRun(t *testing.T) {
+	target := startTextServer(t, "<p>original page</p>")
+	tampering := strings.TrimSuffix(startTextServer(t, "<p>original page</p><script>ad()</script>"), "/")
+	honest := startProxy(t, "http")
+
+	// main fetches the target directly for the reference hash
+	stdout, stderr, code := runProxyra(t, tampering+"\n"+honest+"\n", "-u", target, "-r", "original", "-verify-hash")
+	if code != 0 || strings.TrimSpace(stdout) != honest {
+		t.Fatalf("exit %d stdout=%q stderr=%s", code, stdout, stderr)
+	}
+}
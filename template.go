@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// lockedSource makes a seeded rand.Source safe to share between workers
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+// targetData is the data available to -url-template
+type targetData struct {
+	ProxyHost string // proxy host without port
+	ProxyPort string
+	Rand      string // random hex nonce, fresh per render
+	Timestamp int64  // Unix seconds
+}
+
+func parseURLTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Render once with sample data so bad fields and non-URLs fail at startup
+	sample, err := renderTarget(tmpl, "socks5://127.0.0.1:1080", rand.New(rand.NewPCG(1, 1)))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(sample, "http://") && !strings.HasPrefix(sample, "https://") {
+		return nil, fmt.Errorf("template must render an http:// or https:// URL, got %q", sample)
+	}
+	return tmpl, nil
+}
+
+// renderTarget builds the target URL for one proxy
+func renderTarget(tmpl *template.Template, proxyAddr string, rng *rand.Rand) (string, error) {
+	hostPort := proxyAddr
+	if u, err := url.Parse(proxyAddr); err == nil && u.Host != "" {
+		hostPort = u.Host
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, targetData{
+		ProxyHost: host,
+		ProxyPort: port,
+		Rand:      strconv.FormatUint(rng.Uint64(), 16),
+		Timestamp: time.Now().Unix(),
+	})
+	return sb.String(), err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderTarget(t *testing.T) {
+	tmpl, err := parseURLTemplate("http://echo.example/{{.ProxyHost}}/{{.ProxyPort}}?n={{.Rand}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	a, err := renderTarget(tmpl, "socks5://u:p@10.1.2.3:1080", rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, "http://echo.example/10.1.2.3/1080?n=") {
+		t.Fatalf("rendered %q", a)
+	}
+	b, _ := renderTarget(tmpl, "socks5://u:p@10.1.2.3:1080", rng)
+	if a == b {
+		t.Fatal("Rand repeated between renders")
+	}
+	if c, _ := renderTarget(tmpl, "[2001:db8::1]:3128", rng); !strings.HasPrefix(c, "http://echo.example/2001:db8::1/3128?") {
+		t.Fatalf("bare IPv6 proxy rendered %q", c)
+	}
+}
+
+func TestParseURLTemplateErrors(t *testing.T) {
+	for _, text := range []string{
+		"http://x/{{.Nope}}",
+		"ftp://x/{{.ProxyHost}}",
+		"http://x/{{.ProxyHost",
+	} {
+		if _, err := parseURLTemplate(text); err == nil {
+			t.Errorf("parseURLTemplate(%q) accepted", text)
+		}
+	}
+}
+
+func TestURLTemplateCheck(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte("seen " + r.URL.Path))
+	}))
+	defer srv.Close()
+	tmpl, err := parseURLTemplate(srv.URL + "/{{.ProxyPort}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := startProxy(t, "http")
+	opts := testOptions("", "seen")
+	opts.urlTemplate, opts.rng = tmpl, rand.New(rand.NewPCG(1, 1))
+	if res := checkProxyHTTP(proxy, opts); !res.OK {
+		t.Fatalf("templated check failed: %s", res.Reason)
+	}
+	_, port, _ := strings.Cut(strings.TrimPrefix(proxy, "http://"), ":")
+	if len(paths) != 1 || paths[0] != "/"+port {
+		t.Fatalf("server saw %v, want /%s", paths, port)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tuiRecentAlive = 10
+	tuiSparkWidth  = 40
+)
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// isTerminal reports whether f is attached to a character device
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// dashboard is the state behind -tui. Workers feed it through update and a
+// ticker redraws it on stderr.
+type dashboard struct {
+	mu        sync.Mutex
+	total     *atomic.Int64 // runStats.queued, which grows while input is streamed
+	checked   int
+	alive     int
+	latencies []int64 // most recent alive latencies, oldest first
+	recent    []string
+	failures  map[string]int
+	start     time.Time
+}
+
+func newDashboard(total *atomic.Int64) *dashboard {
+	return &dashboard{total: total, failures: make(map[string]int), start: time.Now()}
+}
+
+func (d *dashboard) update(res Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.checked++
+	if !res.OK {
+		reason := res.Reason
+		if reason == "" {
+			reason = "other"
+		}
+		d.failures[reason]++
+		return
+	}
+	d.alive++
+	d.recent = append(d.recent, res.Proxy)
+	if len(d.recent) > tuiRecentAlive {
+		d.recent = d.recent[1:]
+	}
+	d.latencies = append(d.latencies, res.LatencyMs)
+	if len(d.latencies) > tuiSparkWidth {
+		d.latencies = d.latencies[1:]
+	}
+}
+
+// render draws the whole dashboard, homing the cursor and clearing first
+func (d *dashboard) render(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&sb, "proxyra  elapsed %s\n\n", time.Since(d.start).Round(time.Second))
+	fmt.Fprintf(&sb, "checked %d/%d   alive %d   failed %d\n\n", d.checked, d.total.Load(), d.alive, d.checked-d.alive)
+	fmt.Fprintf(&sb, "latency  %s\n\n", sparkline(d.latencies))
+
+	sb.WriteString("failures\n")
+	reasons := make([]string, 0, len(d.failures))
+	for r := range d.failures {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	for _, r := range reasons {
+		fmt.Fprintf(&sb, "  %-18s %d\n", r, d.failures[r])
+	}
+
+	sb.WriteString("\nrecent alive\n")
+	for i := len(d.recent) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "  %s\n", d.recent[i])
+	}
+	_, _ = io.WriteString(w, sb.String())
+}
+
+// run redraws every interval until stop is closed, then draws a final frame
+func (d *dashboard) run(w io.Writer, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.render(w)
+		case <-stop:
+			d.render(w)
+			return
+		}
+	}
+}
+
+// sparkline scales values between their min and max onto block characters
+func sparkline(values []int64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) * int64(len(sparkChars)-1) / (hi - lo))
+		}
+		sb.WriteRune(sparkChars[i])
+	}
+	fmt.Fprintf(&sb, "  %d-%dms", lo, hi)
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	var total atomic.Int64
+	total.Store(20)
+	d := newDashboard(&total)
+	for i := range 12 {
+		d.update(Result{Proxy: fmt.Sprintf("http://10.0.0.%d:80", i), OK: true, LatencyMs: int64(10 * i)})
+	}
+	d.update(Result{Proxy: "http://10.0.1.1:80", Reason: reasonTimeout})
+	d.update(Result{Proxy: "http://10.0.1.2:80", Reason: reasonTimeout})
+	d.update(Result{Proxy: "http://10.0.1.3:80"})
+
+	var buf bytes.Buffer
+	d.render(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"checked 15/20   alive 12   failed 3",
+		"  timeout            2\n",
+		"  other              1\n",
+		"0-110ms",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dashboard lacks %q:\n%s", want, out)
+		}
+	}
+	// the most recent alive proxies, newest first, and only tuiRecentAlive of them
+	recent := out[strings.Index(out, "recent alive\n")+len("recent alive\n"):]
+	lines := strings.Split(strings.TrimSpace(recent), "\n")
+	if len(lines) != tuiRecentAlive || strings.TrimSpace(lines[0]) != "http://10.0.0.11:80" {
+		t.Errorf("recent alive = %q", lines)
+	}
+}
+
+func TestDashboardRunStops(t *testing.T) {
+	var total atomic.Int64
+	d := newDashboard(&total)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var buf bytes.Buffer
+	go func() {
+		d.run(&buf, time.Hour, stop)
+		close(done)
+	}()
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after stop")
+	}
+	if !strings.Contains(buf.String(), "checked 0/0") {
+		t.Fatalf("no final frame: %q", buf.String())
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if got := sparkline(nil); got != "-" {
+		t.Fatalf("empty sparkline = %q", got)
+	}
+	if got := sparkline([]int64{5, 5}); got != "▁▁  5-5ms" {
+		t.Fatalf("flat sparkline = %q", got)
+	}
+	if got := sparkline([]int64{0, 70, 35}); got != "▁█▄  0-70ms" {
+		t.Fatalf("sparkline = %q", got)
+	}
+}
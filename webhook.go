@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const webhookQueueSize = 1024
+
+// webhookSink POSTs each result as JSON to an endpoint from a small pool of
+// delivery goroutines, so a slow receiver never stalls the checks. A POST
+// that errors or gets a non-2xx answer is retried, see deliveryRetry; a full
+// queue drops the result with a warning instead of blocking.
+type webhookSink struct {
+	url    string
+	client *http.Client
+	retry  deliveryRetry // shared by the delivery goroutines
+	queue  chan []byte
+	wg     sync.WaitGroup
+	logf   func(string, ...any)
+}
+
+func newWebhookSink(url string, workers int, logf func(string, ...any)) *webhookSink {
+	w := &webhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		retry:  deliveryRetry{policy: sinkRetry()},
+		queue:  make(chan []byte, webhookQueueSize),
+		logf:   logf,
+	}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go w.run()
+	}
+	return w
+}
+
+func (w *webhookSink) run() {
+	defer w.wg.Done()
+	for body := range w.queue {
+		if err := w.retry.deliver(func() error { return w.post(body) }); err != nil {
+			w.logf("Warning: webhook delivery failed: %v\n", err)
+		}
+	}
+}
+
+func (w *webhookSink) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *webhookSink) publish(res Result) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	select {
+	case w.queue <- body:
+	default:
+		w.logf("Warning: webhook queue full, dropping result for %s\n", res.Proxy)
+	}
+}
+
+// Close delivers whatever is still queued
+func (w *webhookSink) Close() {
+	close(w.queue)
+	w.wg.Wait()
+}
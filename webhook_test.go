@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ogpourya/proxyra/proxyra"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first delivery fails once and is retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var res Result
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("bad delivery: %v", err)
+		}
+		mu.Lock()
+		got = append(got, res.Proxy)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := newWebhookSink(srv.URL, 1, t.Logf)
+	w.retry.policy = proxyra.ConstantBackoff{Delay: time.Millisecond, Attempts: 3}
+	w.publish(Result{Proxy: "http://10.0.0.1:8080", OK: true})
+	w.publish(Result{Proxy: "http://10.0.0.2:8080", OK: true})
+	w.Close()
+	if len(got) != 2 || got[0] != "http://10.0.0.1:8080" || calls.Load() != 3 {
+		t.Fatalf("delivered %v in %d calls, want both in 3", got, calls.Load())
+	}
+}
+
+func TestWebhookFailing(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var warnings []string
+	w := newWebhookSink(srv.URL, 1, func(format string, a ...any) {
+		mu.Lock()
+		warnings = append(warnings, format)
+		mu.Unlock()
+	})
+	w.retry.policy = proxyra.ConstantBackoff{Delay: time.Millisecond, Attempts: 2}
+	for range 3 {
+		w.publish(Result{Proxy: "http://10.0.0.1:8080"})
+	}
+	w.Close()
+	// the first result uses every retry, later ones get one attempt each
+	if calls.Load() != 3+1+1 {
+		t.Fatalf("%d calls, want 5", calls.Load())
+	}
+	if len(warnings) != 3 || !strings.Contains(warnings[0], "delivery failed") {
+		t.Fatalf("warnings %q", warnings)
+	}
+}
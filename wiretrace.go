@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// wireTracer dumps the full request and response of the first max proxies
+// checked, so -trace-sample gives wire-level detail without flooding the
+// output. Every request made for a sampled proxy is dumped, fallbacks and
+// repeated checks included.
+type wireTracer struct {
+	max     int
+	mu      *sync.Mutex // guards w; the stderr mutex when w is stderr
+	w       io.Writer
+	sampled map[string]bool // admitted proxies
+}
+
+func newWireTracer(max int, w io.Writer, mu *sync.Mutex) *wireTracer {
+	return &wireTracer{max: max, mu: mu, w: w, sampled: make(map[string]bool)}
+}
+
+// sample reports whether proxyAddr's traffic is dumped. The first max
+// distinct proxies asked about are admitted.
+func (t *wireTracer) sample(proxyAddr string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.sampled[proxyAddr] && len(t.sampled) < t.max {
+		t.sampled[proxyAddr] = true
+	}
+	return t.sampled[proxyAddr]
+}
+
+// dump writes one exchange. response is nil when the request failed.
+func (t *wireTracer) dump(proxyAddr string, request, response []byte, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "=== trace %s: request\n%s\n", proxyAddr, request)
+	if err != nil {
+		fmt.Fprintf(t.w, "=== trace %s: error: %v\n", proxyAddr, err)
+		return
+	}
+	fmt.Fprintf(t.w, "=== trace %s: response\n%s\n=== end trace %s\n", proxyAddr, response, proxyAddr)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTraceSample(t *testing.T) {
+	target := startTextServer(t, "traced body")
+	a, b := startProxy(t, "http"), startProxy(t, "socks5")
+	var out bytes.Buffer
+	opts := testOptions(target, "traced")
+	opts.tracer = newWireTracer(1, &out, &sync.Mutex{})
+
+	for _, p := range []string{a, b, a} {
+		if res := checkProxyHTTP(p, opts); !res.OK {
+			t.Fatalf("%s: %s", p, res.Reason)
+		}
+	}
+	trace := out.String()
+	// only the first proxy is sampled, and every one of its checks is dumped
+	if n := strings.Count(trace, "=== trace "+a+": request\nGET / HTTP/1.1"); n != 2 {
+		t.Fatalf("%d request dumps for the sampled proxy, want 2:\n%s", n, trace)
+	}
+	if !strings.Contains(trace, "traced body") || strings.Contains(trace, b) {
+		t.Fatalf("trace:\n%s", trace)
+	}
+
+	// failed requests are dumped with their error
+	out.Reset()
+	opts.tracer = newWireTracer(1, &out, &sync.Mutex{})
+	checkProxyHTTP("http://127.0.0.1:1", opts)
+	if !strings.Contains(out.String(), "=== trace http://127.0.0.1:1: error: ") {
+		t.Fatalf("failed request trace:\n%s", out.String())
+	}
+}